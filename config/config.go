@@ -2,12 +2,53 @@ package config
 
 import (
 	"fmt"
+	"runtime"
+	"time"
 )
 
 func DefaultGoroutinesNum() int32 {
 	return 100
 }
 
+// AutoSizedGoroutinesNum 按可用CPU给出CPU密集型任务合适的goroutine数。
+// 使用runtime.GOMAXPROCS(0)而不是runtime.NumCPU()：前者可被GOMAXPROCS环境变量限制，
+// 且自Go 1.25起会遵守容器的CPU配额，在Kubernetes中不会按宿主机的核数把池子开得过大。
+// IO密集型任务应在此基础上乘以一个系数。
+// 需要主动启用：DefaultGoroutinesNum()仍固定返回100，要按CPU定大小时改为返回该值。
+func AutoSizedGoroutinesNum() int32 {
+	return int32(runtime.GOMAXPROCS(0))
+}
+
+func MaxGoroutinesNum() int32 {
+	return 1000
+}
+
+// NonCoreKeepAliveTime 超出DefaultGoroutinesNum()的goroutine空闲这么久后退出。
+// DefaultGoroutinesNum()个核心goroutine不会因空闲而退出。
+func NonCoreKeepAliveTime() time.Duration {
+	return time.Second
+}
+
+// BatchSize 一个goroutine被唤醒后最多连续执行的Callable数，不必每个都重新select。
+func BatchSize() int {
+	return 16
+}
+
+// ScaleUpQueueThreshold 排队中的Callable超过该数量时才会增加goroutine。
+func ScaleUpQueueThreshold() int {
+	return 10
+}
+
+// HealthyQueueUtilization 队列使用率超过该值时Executors.Health()报告不健康。
+func HealthyQueueUtilization() float64 {
+	return 0.9
+}
+
+// HealthyGoUtilization 忙碌goroutine占比超过该值时Executors.Health()报告不健康。
+func HealthyGoUtilization() float64 {
+	return 0.95
+}
+
 func LoadConfig() {
 	fmt.Println("config loaded.")
 }
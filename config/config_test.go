@@ -0,0 +1,13 @@
+package config
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestAutoSizedGoroutinesNumFollowsGOMAXPROCS(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(3))
+	if n := AutoSizedGoroutinesNum(); n != 3 {
+		t.Fatal("应按GOMAXPROCS而不是NumCPU定大小:", n)
+	}
+}
@@ -0,0 +1,28 @@
+package executors
+
+import (
+	"context"
+)
+
+// CheckCancelled 在ctx已被取消或超时时返回ctx.Err()，否则返回nil。
+// 适合在Callable的循环中定期检查，例如配合Pipe传入的ctx：
+//
+//	for _, item := range items {
+//		if err := executors.CheckCancelled(ctx); err != nil {
+//			return nil, err
+//		}
+//		process(item)
+//	}
+func CheckCancelled(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return nil
+	}
+}
+
+// Cancelled 报告ctx是否已被取消或超时。
+func Cancelled(ctx context.Context) bool {
+	return CheckCancelled(ctx) != nil
+}
@@ -0,0 +1,17 @@
+package executors
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := CheckCancelled(ctx); err != nil || Cancelled(ctx) {
+		t.Fatal("未取消的ctx应返回nil:", err)
+	}
+	cancel()
+	if err := CheckCancelled(ctx); err != context.Canceled || !Cancelled(ctx) {
+		t.Fatal("取消后应返回ctx.Err():", err)
+	}
+}
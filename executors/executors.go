@@ -1,8 +1,17 @@
 package executors
 
 import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
+	"net/http"
 	"runtime"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -13,68 +22,93 @@ type ErrorTimeout string
 
 func (e ErrorTimeout) Error() string { return string(e) }
 
+const ErrTimeout = ErrorTimeout("Callable执行超时错误！")
+
+type ErrorStopped string
+
+func (e ErrorStopped) Error() string { return string(e) }
+
+// ErrStopped与ErrTerminated都是ErrorStopped类型：前者表示已Stop、仍有goroutine在收尾，
+// 后者表示所有goroutine都已退出。
+const (
+	ErrStopped    = ErrorStopped("Executors已停止！")
+	ErrTerminated = ErrorStopped("Executors已终止！")
+)
+
+type ErrorRejected string
+
+func (e ErrorRejected) Error() string { return string(e) }
+
+const (
+	ErrQueueFull     = ErrorRejected("队列已满，等待入队超时！")
+	ErrBatchTooLarge = ErrorRejected("队列剩余容量放不下整批Callable！")
+	ErrSealed        = ErrorRejected("Executors已封闭，暂不接受新的提交！")
+)
+
+// Callable 返回结果和错误。返回(nil, nil)是合法的成功结果：Future照常完成，
+// GetResult得到的ret为nil且三个错误返回值都为nil，以此与超时区分。
 type Callable func() (interface{}, error) // result + error
 type FutureQ chan *Future
 
 type Executors struct {
-	futureQ FutureQ
-	goNum   int32
-	running int32
-}
-
-type Future struct {
-	retChan       chan interface{}
-	errorChan     chan error
-	exceptionChan chan interface{}
-	callable      Callable
-}
-
-func (f *Future) GetResult(timeout time.Duration) (ret interface{}, timeoutError error, err error, exception interface{}) {
-	timer := time.NewTimer(timeout)
-	// fmt.Println("future对应的结果chan:", f.retChan)
-	select {
-	case exception = <-f.exceptionChan:
-		fmt.Println("future 获取到了异常：", exception)
-		return nil, nil, nil, exception
-	case err = <-f.errorChan:
-		fmt.Println("future 获取到了错误：", err)
-		return nil, nil, err, nil
-	case ret = <-f.retChan:
-		fmt.Println("future 获取到了结果：", ret)
-		return ret, nil, nil, nil
-	case <-timer.C:
-		return nil, ErrorTimeout("Callable执行超时错误！"), nil, nil
-	}
+	overdueNum    int64 // 64位原子变量放在最前面以保证在32位平台上对齐
+	maxExecution  int64 // time.Duration，0表示不限制
+	unreadErrNum  int64
+	slowNum       int64
+	slowThreshold int64                // time.Duration，0表示不记录
+	offerTimeout  int64                // time.Duration，0表示一直等待
+	errorNum      [errorClassNum]int64 // 按ErrorClass统计的出错次数
+	futureQ       FutureQ
+	goNum         int32
+	running       int32
+	recoverPanics int32
+	busyNum       int32
+	pendingNum    int32 // 已入队但尚未处理完的Future数
+	scheduledNum  int32 // SubmitAfter、SubmitLimited、SubmitChain持有、尚未入队的Future数
+	warnUnread    int32
+	sealed        int32
+	waitingNum    int32       // 正在GetResult中阻塞等待的调用数
+	cache         futureCache // GetOrSubmit的结果缓存，包括执行中的
+	idempotent    futureCache // SubmitIdempotent按令牌记录的Future
+	keyedMu       sync.Mutex
+	keyed         map[string]map[*Future]struct{} // SubmitWithKey提交且尚未完成的Future
+	panicHandler  atomic.Value                    // func(interface{}) error
+	classifier    atomic.Value                    // func(error) ErrorClass
+	deadLetter    atomic.Value                    // func(Callable, error)
+	beforeExecute atomic.Value                    // func(*Future)
+	afterExecute  atomic.Value                    // func(*Future)
+	successor     atomic.Value                    // *Executors
+	middlewares   atomic.Value                    // []Middleware，只整体替换
+	middlewareMu  sync.Mutex
+	configMu      sync.RWMutex  // Reconfigure持写锁，Config持读锁，使其看到的配置是一致的
+	stopMu        sync.RWMutex  // Submit持读锁检查running并入队，Stop持写锁修改running
+	stopped       chan struct{} // Stop时关闭
+	stopOnce      sync.Once
 }
 
 func NewExecutors() *Executors {
 	var fq = make(FutureQ, 100)
-	var es = &Executors{fq, 0, 1}
+	var es = &Executors{futureQ: fq, running: 1, recoverPanics: 1, stopped: make(chan struct{})}
 	var goMainFunc = func() {
-		atomic.AddInt32(&es.goNum, 1)
-		defer atomic.AddInt32(&es.goNum, -1)
+		retired := false
+		defer func() {
+			// 包括Callable中调用runtime.Goexit的情况，ControlGoNum会补上缺少的goroutine
+			if !retired {
+				atomic.AddInt32(&es.goNum, -1)
+			}
+		}()
 		for atomic.LoadInt32(&es.running) == 1 {
-			var err interface{}
 			select {
 			case future := <-fq:
-				defer func() {
-					if err = recover(); err != nil {
-						fmt.Errorf("捕获了一个错误:%v", err)
-						future.exceptionChan <- err
-					}
-				}()
-				ret, callableError := future.callable()
-				// fmt.Println("result chan:", retChan)
-				if callableError != nil {
-					future.errorChan <- callableError
-				} else if ret != nil {
-					future.retChan <- ret
-				}
-			case <-time.After(time.Second * 1):
+				es.execute(future)
+				es.executeBatch(config.BatchSize() - 1)
+			case <-es.stopped:
+			case <-time.After(config.NonCoreKeepAliveTime()):
 				runtime.Gosched()
-				if es.GetGoNum() > config.DefaultGoroutinesNum() {
+				if es.retireIdleGo() {
+					retired = true
 					fmt.Println("idle gorotine.", es.GetGoNum())
-					runtime.Goexit()
+					return
 				}
 			}
 			// fmt.Println(".")
@@ -82,7 +116,7 @@ func NewExecutors() *Executors {
 	}
 	var i int32 = 0
 	for ; i < config.DefaultGoroutinesNum(); i++ {
-		go goMainFunc()
+		es.startGo(goMainFunc)
 	}
 	es.ControlGoNum(goMainFunc)
 
@@ -90,42 +124,1393 @@ func NewExecutors() *Executors {
 
 }
 
+// execute 在当前goroutine中运行future的Callable。
+// 默认会捕获Callable中的panic并作为异常交给Future；关闭捕获后panic会直接终止整个进程。
+func (es *Executors) execute(future *Future) {
+	defer atomic.AddInt32(&es.pendingNum, -1)
+	defer func() {
+		// 正常返回、panic被捕获或被跳过时future都已完成；否则是Callable调用了runtime.Goexit
+		if !future.IsDone() {
+			future.complete(nil, nil, "Callable中调用了runtime.Goexit")
+		}
+	}()
+	if atomic.LoadInt32(&es.recoverPanics) == 1 {
+		defer func() {
+			if err := recover(); err != nil {
+				fmt.Println("捕获了一个错误:", err)
+				if future.IsDone() {
+					// 例如Future完成之后的回调中发生了panic，死信已经处理过（或不需要处理）
+					fmt.Println("Future已经完成过，丢弃了异常:", err)
+					es.runHook(&es.afterExecute, future)
+					return
+				}
+				if mapped := es.mapPanic(err); mapped != nil {
+					es.classify(future, mapped)
+					es.sendToDeadLetter(future, mapped)
+					future.complete(nil, mapped, nil)
+					es.runHook(&es.afterExecute, future)
+					return
+				}
+				es.sendToDeadLetter(future, fmt.Errorf("Callable发生异常: %v", err))
+				future.complete(nil, nil, err)
+				es.runHook(&es.afterExecute, future)
+			}
+		}()
+	}
+	if future.ctx != nil && future.ctx.Err() != nil || future.pastDeadline() {
+		if atomic.CompareAndSwapInt32(&future.state, futurePending, futureCancelled) {
+			if future.pastDeadline() {
+				if !future.completeTimedOut() {
+					fmt.Println("警告：Future已经完成过，丢弃了超时结果")
+				}
+			} else {
+				future.complete(nil, future.ctx.Err(), nil)
+			}
+		}
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&future.state, futurePending, futureRunning) {
+		return // 已被取消
+	}
+	atomic.AddInt32(&es.busyNum, 1)
+	defer atomic.AddInt32(&es.busyNum, -1)
+	if d := time.Duration(atomic.LoadInt64(&es.maxExecution)); d > 0 {
+		watchdog := time.AfterFunc(d, func() {
+			atomic.AddInt64(&es.overdueNum, 1)
+			fmt.Println("警告：Callable执行超过", d, "仍未结束。")
+		})
+		defer watchdog.Stop()
+	}
+	if threshold := time.Duration(atomic.LoadInt64(&es.slowThreshold)); threshold > 0 {
+		start := time.Now()
+		defer func() {
+			if elapsed := time.Since(start); elapsed > threshold {
+				atomic.AddInt64(&es.slowNum, 1)
+				fmt.Println("警告：Callable执行了", elapsed, "，超过了慢任务阈值", threshold)
+			}
+		}()
+	}
+	es.runHook(&es.beforeExecute, future)
+	run := future.callable
+	if future.fallback != nil {
+		run = future.fallback
+	}
+	ret, callableError := run()
+	if future.pastDeadline() {
+		if !future.completeTimedOut() {
+			fmt.Println("警告：Future已经完成过，丢弃了超时结果")
+		}
+	} else {
+		if callableError != nil {
+			es.classify(future, callableError)
+			es.sendToDeadLetter(future, callableError)
+		}
+		future.complete(ret, callableError, nil)
+	}
+	es.runHook(&es.afterExecute, future)
+}
+
+// SetExecuteHooks 设置每个Callable执行前后的回调，类似Java ThreadPoolExecutor的
+// beforeExecute/afterExecute。after在Future完成之后调用（包括出错和panic被捕获的情况），
+// 可用TryGet读取结果。回调中的panic会被捕获并忽略。传nil表示不设置。
+func (es *Executors) SetExecuteHooks(before, after func(*Future)) {
+	es.beforeExecute.Store(before)
+	es.afterExecute.Store(after)
+}
+
+func (es *Executors) runHook(hook *atomic.Value, future *Future) {
+	fn, _ := hook.Load().(func(*Future))
+	if fn == nil {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Println("回调中发生了panic:", err)
+		}
+	}()
+	fn(future)
+}
+
+// SetDeadLetter 设置死信处理函数：Callable返回错误或发生异常时以该Callable和错误调用一次，
+// 可用于持久化或报警。处理函数中的panic会被捕获并忽略。Executors本身不重试，需要重试的话应在Callable内部完成。
+func (es *Executors) SetDeadLetter(deadLetter func(callable Callable, err error)) {
+	es.deadLetter.Store(deadLetter)
+}
+
+func (es *Executors) sendToDeadLetter(future *Future, err error) {
+	deadLetter, _ := es.deadLetter.Load().(func(Callable, error))
+	if deadLetter == nil {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Println("死信处理函数中发生了panic:", err)
+		}
+	}()
+	deadLetter(future.callable, err)
+}
+
+// SetRecoverPanics 设置是否捕获Callable中的panic，默认为true。
+// 设为false仅用于调试：panic不再转换为Future的异常，而是带着原始调用栈使整个进程崩溃，
+// 其他正在执行或排队的Callable都会随之丢失，切勿在生产环境中关闭。
+func (es *Executors) SetRecoverPanics(recoverPanics bool) {
+	var v int32
+	if recoverPanics {
+		v = 1
+	}
+	atomic.StoreInt32(&es.recoverPanics, v)
+}
+
+// executeBatch 不阻塞地从队列中再取最多n个Future依次执行，
+// 分摊每轮select及其空闲定时器的开销；每个Future仍各自完成。
+func (es *Executors) executeBatch(n int) {
+	for ; n > 0; n-- {
+		select {
+		case future := <-es.futureQ:
+			es.execute(future)
+		default:
+			return
+		}
+	}
+}
+
+// startGo 在不超过config.MaxGoroutinesNum()的前提下启动一个工作goroutine。
+// goNum在启动前就已计入，因此并发调用也不会超出上限。
+func (es *Executors) startGo(goMainFunc func()) bool {
+	for {
+		n := es.GetGoNum()
+		if n >= config.MaxGoroutinesNum() {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&es.goNum, n, n+1) {
+			go goMainFunc()
+			return true
+		}
+	}
+}
+
+// retireIdleGo 当goroutine数多于config.DefaultGoroutinesNum()时让一个空闲goroutine退出。
+func (es *Executors) retireIdleGo() bool {
+	for {
+		n := es.GetGoNum()
+		if n <= config.DefaultGoroutinesNum() {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&es.goNum, n, n-1) {
+			return true
+		}
+	}
+}
+
+// newFuture 创建经过中间件包装的Future，开启了SetWarnUnreadFutures时同时登记未读检查。
+func (es *Executors) newFuture(callable Callable) *Future {
+	future := es.newInternalFuture(callable)
+	if atomic.LoadInt32(&es.warnUnread) == 1 {
+		future.guard = &unreadGuard{es: es}
+		runtime.SetFinalizer(future.guard, (*unreadGuard).check)
+	}
+	return future
+}
+
+// newInternalFuture 与newFuture相同但不登记未读检查，用于不会交给调用者、因而无人读取的Future。
+func (es *Executors) newInternalFuture(callable Callable) *Future {
+	future := newFuture(es.wrap(callable))
+	future.waitingNum = &es.waitingNum
+	return future
+}
+
+// unreadGuard 在Future被回收时检查其错误是否被读取过。它不引用Future，
+// 因此不会与Future形成带finalizer的环而妨碍回收。
+type unreadGuard struct {
+	es     *Executors
+	read   int32
+	failed int32
+}
+
+func (g *unreadGuard) check() {
+	if atomic.LoadInt32(&g.failed) == 1 && atomic.LoadInt32(&g.read) == 0 {
+		atomic.AddInt64(&g.es.unreadErrNum, 1)
+		fmt.Println("警告：一个出错的Future从未被读取就被回收了。")
+	}
+}
+
+// SetWarnUnreadFutures 开启后，之后创建的Future如果出错或panic、却在被GC回收前
+// 从未通过GetResult或TryGet等读取过，就记录一条警告并计入GetUnreadErrorNum()。
+// 依赖finalizer，警告时间取决于GC，仅建议在开发调试时开启。
+func (es *Executors) SetWarnUnreadFutures(warn bool) {
+	var v int32
+	if warn {
+		v = 1
+	}
+	atomic.StoreInt32(&es.warnUnread, v)
+}
+
+func (es *Executors) GetUnreadErrorNum() int64 {
+	return atomic.LoadInt64(&es.unreadErrNum)
+}
+
+// Middleware 在提交时包装Callable，用于计时、日志等横切逻辑。
+type Middleware func(Callable) Callable
+
+// Use 追加中间件。之后提交的每个Callable都会被包装一次，先注册的在最外层。
+func (es *Executors) Use(middlewares ...Middleware) {
+	es.middlewareMu.Lock()
+	defer es.middlewareMu.Unlock()
+	old, _ := es.middlewares.Load().([]Middleware)
+	all := make([]Middleware, 0, len(old)+len(middlewares))
+	all = append(append(all, old...), middlewares...)
+	es.middlewares.Store(all)
+}
+
+// wrap 用已注册的中间件包装callable。中间件在包装时panic的话，
+// 该panic会在Callable执行时重新抛出，按普通的Callable异常处理。
+func (es *Executors) wrap(callable Callable) (wrapped Callable) {
+	middlewares, _ := es.middlewares.Load().([]Middleware)
+	if len(middlewares) == 0 {
+		return callable
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			wrapped = func() (interface{}, error) { panic(err) }
+		}
+	}()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		callable = middlewares[i](callable)
+	}
+	return callable
+}
+
+// SetSlowThreshold 设置慢任务阈值：执行时间超过threshold的Callable在结束后记录一条警告，
+// 并计入GetSlowNum()，但不会被中断。0表示不记录。
+func (es *Executors) SetSlowThreshold(threshold time.Duration) {
+	atomic.StoreInt64(&es.slowThreshold, int64(threshold))
+}
+
+func (es *Executors) GetSlowNum() int64 {
+	return atomic.LoadInt64(&es.slowNum)
+}
+
+// SetPanicHandler 设置把Callable中panic的值转换为error的函数。
+// 返回非nil时Future得到该error（可用errors.As判断类型），返回nil或handler本身panic时仍作为异常交给Future。
+func (es *Executors) SetPanicHandler(handler func(recovered interface{}) error) {
+	es.panicHandler.Store(handler)
+}
+
+// mapPanic 用SetPanicHandler设置的函数转换panic的值，未设置或该函数本身panic时返回nil。
+func (es *Executors) mapPanic(recovered interface{}) (mapped error) {
+	handler, _ := es.panicHandler.Load().(func(interface{}) error)
+	if handler == nil {
+		return nil
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Println("panic处理函数中发生了panic:", err)
+			mapped = nil
+		}
+	}()
+	return handler(recovered)
+}
+
+// ErrorClass 是Callable返回的错误的分类，供重试、死信等逻辑区分处理。
+type ErrorClass int
+
+const (
+	ErrorUnknown   ErrorClass = iota // 未设置分类函数或无法判断
+	ErrorTransient                   // 暂时性错误，重试可能成功
+	ErrorPermanent                   // 永久性错误，重试也不会成功
+	errorClassNum
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorTransient:
+		return "transient"
+	case ErrorPermanent:
+		return "permanent"
+	default:
+		return "unknown"
+	}
+}
+
+// SetErrorClassifier 设置对Callable返回的错误（以及SetPanicHandler转换得到的错误）分类的函数，
+// 结果可通过Future.Class()读取，并按分类计入GetErrorNum。未设置时所有错误都是ErrorUnknown，
+// 分类函数中的panic会被捕获，该错误同样按ErrorUnknown处理。
+func (es *Executors) SetErrorClassifier(classifier func(err error) ErrorClass) {
+	es.classifier.Store(classifier)
+}
+
+// GetErrorNum 返回分类为class的错误总数。
+func (es *Executors) GetErrorNum(class ErrorClass) int64 {
+	if class < 0 || class >= errorClassNum {
+		return 0
+	}
+	return atomic.LoadInt64(&es.errorNum[class])
+}
+
+func (es *Executors) classify(future *Future, err error) {
+	class := es.classOf(err)
+	future.class = class
+	atomic.AddInt64(&es.errorNum[class], 1)
+}
+
+// classOf 调用分类函数，未设置、返回值无效或分类函数panic时为ErrorUnknown。
+func (es *Executors) classOf(err error) (class ErrorClass) {
+	classifier, _ := es.classifier.Load().(func(error) ErrorClass)
+	if classifier == nil {
+		return ErrorUnknown
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			fmt.Println("错误分类函数中发生了panic:", e)
+			class = ErrorUnknown
+		}
+	}()
+	if class = classifier(err); class < 0 || class >= errorClassNum {
+		class = ErrorUnknown
+	}
+	return class
+}
+
+// SetMaxExecutionTime 设置单个Callable的最长执行时间，0表示不限制。
+// 超时的Callable会被记录一条警告并计入GetOverdueNum()，但Callable不接收context，
+// 无法被中断，仍会执行到结束。
+func (es *Executors) SetMaxExecutionTime(d time.Duration) {
+	atomic.StoreInt64(&es.maxExecution, int64(d))
+}
+
+// GetOverdueNum 返回执行时间超过SetMaxExecutionTime上限的Callable总数。
+func (es *Executors) GetOverdueNum() int64 {
+	return atomic.LoadInt64(&es.overdueNum)
+}
+
+// ControlGoNum 维持至少config.DefaultGoroutinesNum()个goroutine，
+// 并且只在积压超过config.ScaleUpQueueThreshold()时才逐个增加goroutine。
 func (es *Executors) ControlGoNum(goMainFunc func()) {
 	go func() {
 		for atomic.LoadInt32(&es.running) == 1 {
 			runtime.Gosched()
-			if es.GetGoNum() < config.DefaultGoroutinesNum() || len(es.futureQ) > 10 {
-				runtime.Gosched()
-				if es.GetGoNum() < config.DefaultGoroutinesNum() || len(es.futureQ) > 10 {
-					fmt.Println("GoNum:", es.GetGoNum(), "len(es.futureQ):", len(es.futureQ))
-					go goMainFunc()
-					go goMainFunc()
-					go goMainFunc()
-					go goMainFunc()
-					go goMainFunc()
-					runtime.Gosched()
-				}
-			} else {
-				time.Sleep(time.Millisecond * 200)
-				runtime.Gosched()
+			switch {
+			case es.GetGoNum() < config.DefaultGoroutinesNum():
+				es.startGo(goMainFunc)
+			case len(es.futureQ) > config.ScaleUpQueueThreshold() && es.startGo(goMainFunc):
+				fmt.Println("GoNum:", es.GetGoNum(), "len(es.futureQ):", len(es.futureQ))
+				// 给新goroutine一点时间消化积压，避免一下子扩到上限
+				es.sleep(time.Millisecond * 10)
+			default:
+				es.sleep(time.Millisecond * 200)
 			}
 		}
 	}()
 }
 
+// sleep 睡眠d，Stop时提前醒来，使内部的后台goroutine能及时退出。
+func (es *Executors) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-es.stopped:
+	}
+}
+
 func (es *Executors) GetGoNum() int32 {
 	return atomic.LoadInt32(&es.goNum)
 }
 
+func (es *Executors) GetBusyNum() int32 {
+	return atomic.LoadInt32(&es.busyNum)
+}
+
+// GetWaitingNum 返回当前阻塞在GetResult中等待结果的调用数，长时间居高不下通常说明有Callable卡住了。
+func (es *Executors) GetWaitingNum() int32 {
+	return atomic.LoadInt32(&es.waitingNum)
+}
+
+// Stop 停止Executors。Stop返回之后提交的Callable不会再进入队列，
+// 其Future会直接得到ErrStopped错误（goroutine全部退出后为ErrTerminated）。
+// 正在执行的Callable会执行完毕；仍在队列中、没有被goroutine取走的Callable不再执行，
+// 其Future同样得到ErrStopped，而不会让GetResult一直等到超时。
 func (es *Executors) Stop() {
+	es.stop()
+	es.drainQueue(func(future *Future) {
+		if atomic.CompareAndSwapInt32(&future.state, futurePending, futureCancelled) {
+			future.complete(nil, ErrStopped, nil)
+		}
+	})
+}
+
+func (es *Executors) stop() {
+	// 先关闭stopped，让持有读锁、阻塞在入队上的提交者退出，否则这里拿不到写锁
+	es.stopOnce.Do(func() { close(es.stopped) })
+	es.stopMu.Lock()
 	atomic.StoreInt32(&es.running, 0)
+	es.stopMu.Unlock()
+}
+
+// drainQueue 不阻塞地取出队列中剩余的Future交给fn。
+func (es *Executors) drainQueue(fn func(*Future)) {
+	for {
+		select {
+		case future := <-es.futureQ:
+			atomic.AddInt32(&es.pendingNum, -1)
+			fn(future)
+		default:
+			return
+		}
+	}
+}
+
+// Seal 封闭Executors：之后的提交直接以ErrSealed完成，队列中已有的Callable照常执行。
+// 比Stop温和，可在计划Stop之前先让生产者退避，之后也可用Unseal重新开放。
+func (es *Executors) Seal() {
+	atomic.StoreInt32(&es.sealed, 1)
+}
+
+func (es *Executors) Unseal() {
+	atomic.StoreInt32(&es.sealed, 0)
+}
+
+// AwaitTermination 在Stop之后等待所有goroutine退出，超时返回false。
+func (es *Executors) AwaitTermination(timeout time.Duration) bool {
+	return es.AwaitTerminationProgress(timeout, 0, nil)
+}
+
+// AwaitTerminationProgress 与AwaitTermination相同，但等待期间每隔interval调用一次progress，
+// 参数是尚未处理完的Future数（包括排队、执行中以及SubmitAfter等尚未入队的），便于在停机脚本中显示进度。
+// progress在调用者的goroutine中执行，返回时不会再被调用。
+func (es *Executors) AwaitTerminationProgress(timeout, interval time.Duration, progress func(remaining int)) bool {
+	deadline := time.Now().Add(timeout)
+	next := time.Now().Add(interval)
+	for es.GetGoNum() > 0 {
+		now := time.Now()
+		if now.After(deadline) {
+			return false
+		}
+		if progress != nil && !now.Before(next) {
+			progress(int(es.unfinishedNum()))
+			next = now.Add(interval)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return true
+}
+
+// StopWhenDone 把Executors的生命周期绑定到ctx：ctx结束时调用Stop，
+// 并最多等待timeout让goroutine退出。Executors先被停止的话，监视的goroutine也随之退出。
+func (es *Executors) StopWhenDone(ctx context.Context, timeout time.Duration) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			es.Stop()
+			if !es.AwaitTermination(timeout) {
+				fmt.Println("等待goroutine退出超时，GoNum:", es.GetGoNum())
+			}
+		case <-es.stopped:
+		}
+	}()
+}
+
+// enqueue 把future放入队列；已封闭或入队超时时以相应错误完成future。
+// Executors已停止时转交给继任者，没有继任者则直接以ErrStopped或ErrTerminated完成future。
+// 未能入队时返回该错误。
+// 检查running与入队在同一把读锁内完成，不会与Stop交错；完成future时已释放读锁，
+// 以免onDone回调中再次提交时重复加读锁。
+func (es *Executors) enqueue(future *Future) error {
+	return es.enqueueContext(context.Background(), future)
+}
+
+// enqueueContext 与enqueue相同，但ctx结束时放弃等待入队并返回ctx.Err()，
+// 此时future既没有入队也没有被完成，由调用者决定如何完成。
+func (es *Executors) enqueueContext(ctx context.Context, future *Future) error {
+	es.stopMu.RLock()
+	if atomic.LoadInt32(&es.running) == 1 {
+		atomic.AddInt32(&es.pendingNum, 1)
+		err := error(ErrSealed)
+		if atomic.LoadInt32(&es.sealed) == 0 {
+			err = es.offer(ctx, future)
+		}
+		es.stopMu.RUnlock()
+		if err == nil {
+			return nil
+		}
+		atomic.AddInt32(&es.pendingNum, -1)
+		if ctxErr := ctx.Err(); ctxErr != nil && err == ctxErr {
+			return err
+		}
+		if err != ErrStopped {
+			if atomic.CompareAndSwapInt32(&future.state, futurePending, futureCancelled) {
+				future.complete(nil, err, nil)
+			}
+			return err
+		}
+		// 等待入队期间被Stop，与Stop之后的提交一样处理
+	} else {
+		es.stopMu.RUnlock()
+	}
+	if next := es.getSuccessor(); next != nil {
+		return next.enqueueContext(ctx, future)
+	}
+	err := ErrStopped
+	if es.State() == StateTerminated {
+		err = ErrTerminated
+	}
+	if atomic.CompareAndSwapInt32(&future.state, futurePending, futureCancelled) {
+		future.complete(nil, err, nil)
+	}
+	return err
+}
+
+// offer 把future放入队列，队列满时最多等待SetOfferTimeout设置的时长，
+// 等待期间被Stop或ctx结束时提前返回。
+func (es *Executors) offer(ctx context.Context, future *Future) error {
+	d := time.Duration(atomic.LoadInt64(&es.offerTimeout))
+	if d <= 0 {
+		select {
+		case es.futureQ <- future:
+			return nil
+		case <-es.stopped:
+			return ErrStopped
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	select {
+	case es.futureQ <- future:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case es.futureQ <- future:
+		return nil
+	case <-es.stopped:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return ErrQueueFull
+	}
+}
+
+// SetOfferTimeout 设置队列满时提交最多等待多久，超时后Future直接得到ErrQueueFull。
+// 它只限制入队的等待，与SubmitWithTimeout等对Callable本身的超时无关。0表示一直等待（默认）。
+func (es *Executors) SetOfferTimeout(d time.Duration) {
+	atomic.StoreInt64(&es.offerTimeout, int64(d))
+}
+
+var successorMu sync.Mutex // 保证并发调用SetSuccessor时的环检查
+
+// SetSuccessor 设置继任的Executors：本Executors停止后提交的Callable会转交给next，
+// 而不是得到ErrStopped，用于不停机地替换Executors。next为nil时取消继任；
+// 继任链形成环时返回错误。
+func (es *Executors) SetSuccessor(next *Executors) error {
+	successorMu.Lock()
+	defer successorMu.Unlock()
+	for e := next; e != nil; e = e.getSuccessor() {
+		if e == es {
+			return errors.New("继任的Executors形成了环！")
+		}
+	}
+	es.successor.Store(next)
+	return nil
+}
+
+func (es *Executors) getSuccessor() *Executors {
+	next, _ := es.successor.Load().(*Executors)
+	return next
+}
+
+// StopNowFunc 停止Executors并取出所有尚未执行的Future，逐个取消后交给fn处理，
+// 适合在队列很长时边取边记录，而不必先收集成一个大slice。正在执行的Callable不受影响。
+func (es *Executors) StopNowFunc(fn func(*Future)) {
+	es.stop()
+	es.drainQueue(func(future *Future) {
+		if future.Cancel() {
+			fn(future)
+		}
+	})
+}
+
+// StopNow 停止Executors并返回所有被取消的、尚未执行的Future。
+func (es *Executors) StopNow() []*Future {
+	var futures []*Future
+	es.StopNowFunc(func(future *Future) {
+		futures = append(futures, future)
+	})
+	return futures
+}
+
+// unfinishedNum 返回尚未处理完的Future数，包括已入队或正在执行的，以及SubmitAfter等持有、尚未入队的。
+// 转交时先增加pendingNum再减少scheduledNum，因此这里先读scheduledNum，不会在转交途中读到0。
+func (es *Executors) unfinishedNum() int32 {
+	scheduled := atomic.LoadInt32(&es.scheduledNum)
+	return scheduled + atomic.LoadInt32(&es.pendingNum)
+}
+
+// Quiesce 等待到此刻为止提交的Callable全部处理完毕后返回，Executors可以继续使用；
+// ctx被取消时返回ctx.Err()。等待期间仍有新的提交时会一并等待，因此这只是尽力而为的屏障。
+// SubmitAfter尚未到时间、SubmitLimited和SubmitChain尚未入队的部分也算作未处理完。
+func (es *Executors) Quiesce(ctx context.Context) error {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for es.unfinishedNum() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// StopAfterIdle 在队列为空且没有Callable在执行的状态持续idle之后自动调用Stop，
+// 适合只做一阵子工作的短命程序。idle<=0时不做任何事。
+func (es *Executors) StopAfterIdle(idle time.Duration) {
+	if idle <= 0 {
+		return
+	}
+	interval := idle / 10
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var idleSince time.Time
+		for atomic.LoadInt32(&es.running) == 1 {
+			select {
+			case <-ticker.C:
+			case <-es.stopped:
+				return
+			}
+			if es.unfinishedNum() > 0 {
+				idleSince = time.Time{}
+				continue
+			}
+			if idleSince.IsZero() {
+				idleSince = time.Now()
+			} else if time.Since(idleSince) >= idle {
+				fmt.Println("空闲超过", idle, "自动停止。")
+				es.Stop()
+				return
+			}
+		}
+	}()
 }
 
 func (es *Executors) Submit(callable Callable) *Future {
-	retChan := make(chan interface{}, 1)
-	errorChan := make(chan error, 1)
-	exceptionChan := make(chan interface{}, 1)
-	future := &Future{retChan, errorChan, exceptionChan, callable}
-	es.futureQ <- future
+	future := es.newFuture(callable)
+	es.enqueue(future)
 	return future
 }
+
+// Go 提交一个没有返回值的函数，成功时Future的结果为nil，panic照常作为异常交给Future。
+func (es *Executors) Go(fn func()) *Future {
+	return es.Submit(func() (interface{}, error) {
+		fn()
+		return nil, nil
+	})
+}
+
+// SubmitAfter 在delay之后才把callable放入队列，立即返回Future。
+// 在此之前调用Future.Cancel的话，定时器随即停止，callable不会进入队列。
+func (es *Executors) SubmitAfter(callable Callable, delay time.Duration) *Future {
+	future := es.newFuture(callable)
+	atomic.AddInt32(&es.scheduledNum, 1)
+	timer := time.NewTimer(delay)
+	go func() {
+		defer atomic.AddInt32(&es.scheduledNum, -1)
+		select {
+		case <-timer.C:
+			if atomic.LoadInt32(&future.state) == futurePending {
+				es.enqueue(future)
+			}
+		case <-future.done:
+			timer.Stop()
+		}
+	}()
+	return future
+}
+
+// Execute 提交一个不关心结果的Callable，与Java的Executor.execute相同。
+// Callable的返回值被丢弃，panic照常被捕获；Executors已停止时返回ErrStopped或ErrTerminated。
+func (es *Executors) Execute(callable Callable) error {
+	return es.enqueue(es.newInternalFuture(callable))
+}
+
+// SubmitWithTimeout 与Submit类似，但整个Callable（包括排队时间）必须在timeout内完成：
+// 超过之后GetResult通过timeoutError报告超时，尚未开始的Callable不再执行。
+// 已经开始执行的Callable无法被中断，其迟到的结果会被丢弃。
+func (es *Executors) SubmitWithTimeout(callable Callable, timeout time.Duration) *Future {
+	future := es.newFuture(callable)
+	future.timeout = timeout
+	future.deadline = time.Now().Add(timeout)
+	es.enqueue(future)
+	return future
+}
+
+// Group 是共享同一个截止时间的一组Future。
+type Group struct {
+	Futures  []*Future
+	deadline time.Time
+}
+
+// SubmitGroup 提交一组共享timeout预算的Callable：所有Callable必须在同一个截止时间前完成，
+// 截止时尚未开始的不再执行，各自的GetResult报告超时。
+func (es *Executors) SubmitGroup(callables []Callable, timeout time.Duration) *Group {
+	g := &Group{Futures: make([]*Future, len(callables)), deadline: time.Now().Add(timeout)}
+	for i, callable := range callables {
+		future := es.newFuture(callable)
+		future.timeout = timeout
+		future.deadline = g.deadline
+		g.Futures[i] = future
+	}
+	for _, future := range g.Futures {
+		es.enqueue(future)
+	}
+	return g
+}
+
+// Wait 等待组内所有Future完成或共享的截止时间到达，全部按时完成时返回true。
+func (g *Group) Wait() bool {
+	timer := time.NewTimer(time.Until(g.deadline))
+	defer timer.Stop()
+	for _, future := range g.Futures {
+		select {
+		case <-future.done:
+		case <-timer.C:
+			return false
+		}
+	}
+	for _, future := range g.Futures {
+		if future.timedOut {
+			return false
+		}
+	}
+	return true
+}
+
+// SubmitWithContext 与Submit类似，但ctx在Callable开始执行前被取消或超时的话，
+// Callable不会执行，Future直接得到ctx.Err()。Callable开始执行后ctx不再起作用。
+func (es *Executors) SubmitWithContext(ctx context.Context, callable Callable) *Future {
+	future := es.newFuture(callable)
+	future.ctx = ctx
+	es.enqueue(future)
+	return future
+}
+
+// SubmitTo 提交callable，完成后把其Future送到调用方提供的out中，便于接入已有的chan流水线。
+// out有空位时直接送入，否则交给新的goroutine等待，不会阻塞执行Callable的goroutine。
+// Executors已停止时Future同样会送到out，并返回相应的错误。
+func (es *Executors) SubmitTo(callable Callable, out chan<- *Future) error {
+	future := es.newFuture(callable)
+	future.onDone = func(f *Future) {
+		select {
+		case out <- f:
+		default:
+			go func() { out <- f }()
+		}
+	}
+	return es.enqueue(future)
+}
+
+// SubmitWithKey 与Submit类似，但以key登记Future，之后可用CancelByKey成批取消。
+// Future完成后自动注销。
+func (es *Executors) SubmitWithKey(key string, callable Callable) *Future {
+	future := es.newFuture(callable)
+	future.onDone = func(f *Future) {
+		es.keyedMu.Lock()
+		delete(es.keyed[key], f)
+		if len(es.keyed[key]) == 0 {
+			delete(es.keyed, key)
+		}
+		es.keyedMu.Unlock()
+	}
+	es.keyedMu.Lock()
+	if es.keyed == nil {
+		es.keyed = make(map[string]map[*Future]struct{})
+	}
+	if es.keyed[key] == nil {
+		es.keyed[key] = make(map[*Future]struct{})
+	}
+	es.keyed[key][future] = struct{}{}
+	es.keyedMu.Unlock()
+	es.enqueue(future)
+	return future
+}
+
+// CancelByKey 取消以key提交且尚未开始执行的Future，返回实际取消的个数。
+// 已经开始执行的Callable无法被中断，不计入返回值。
+func (es *Executors) CancelByKey(key string) int {
+	es.keyedMu.Lock()
+	futures := make([]*Future, 0, len(es.keyed[key]))
+	for future := range es.keyed[key] {
+		futures = append(futures, future)
+	}
+	es.keyedMu.Unlock()
+	n := 0
+	for _, future := range futures {
+		if future.Cancel() {
+			n++
+		}
+	}
+	return n
+}
+
+// SubmitWithCleanup 与Submit类似，但无论Callable成功、出错、panic，
+// 还是因取消、超时、停止而没有执行，Future完成后都会调用一次cleanup，用于释放资源。
+// cleanup在完成Future的goroutine中执行，其中的panic会被捕获并忽略。
+func (es *Executors) SubmitWithCleanup(callable Callable, cleanup func()) *Future {
+	future := es.newFuture(callable)
+	future.onDone = func(*Future) {
+		defer func() {
+			if err := recover(); err != nil {
+				fmt.Println("cleanup中发生了panic:", err)
+			}
+		}()
+		cleanup()
+	}
+	es.enqueue(future)
+	return future
+}
+
+// GetOrSubmit 返回key对应的Future：已有执行中的或ttl内成功完成的Future时直接复用，
+// 否则提交callable。并发的相同请求只会执行一次callable，避免缓存击穿。
+// 出错或panic的结果不缓存；成功的结果在完成ttl之后失效。
+func (es *Executors) GetOrSubmit(key string, callable Callable, ttl time.Duration) *Future {
+	return es.cache.getOrSubmit(es, key, callable, ttl, false)
+}
+
+// SubmitIdempotent 按幂等令牌token去重：同一token的Callable正在执行时共享其Future，
+// window内已经执行完的直接返回那个已完成的Future，重放之前的结果（包括错误和异常），不会再次执行。
+// 因取消、停止等原因根本没有执行的不计入。与GetOrSubmit的缓存互不影响。
+func (es *Executors) SubmitIdempotent(token string, callable Callable, window time.Duration) *Future {
+	return es.idempotent.getOrSubmit(es, token, callable, window, true)
+}
+
+// futureCache 按key保存执行中以及最近完成的Future，供GetOrSubmit和SubmitIdempotent使用。
+type futureCache struct {
+	mu sync.Mutex
+	m  map[string]*Future
+}
+
+func (c *futureCache) getOrSubmit(es *Executors, key string, callable Callable, ttl time.Duration, keepFailures bool) *Future {
+	c.mu.Lock()
+	if future, ok := c.m[key]; ok {
+		c.mu.Unlock()
+		return future
+	}
+	if c.m == nil {
+		c.m = make(map[string]*Future)
+	}
+	future := es.newFuture(callable)
+	evict := func() {
+		c.mu.Lock()
+		if c.m[key] == future {
+			delete(c.m, key)
+		}
+		c.mu.Unlock()
+	}
+	future.onDone = func(f *Future) {
+		notRun := atomic.LoadInt32(&f.state) != futureRunning
+		failed := f.err != nil || f.exception != nil || f.timedOut
+		if notRun || failed && !keepFailures || ttl <= 0 {
+			evict()
+		} else {
+			time.AfterFunc(ttl, evict)
+		}
+	}
+	c.m[key] = future
+	c.mu.Unlock()
+	es.enqueue(future) // 已停止时会立即完成并回调evict，因此不能持有c.mu
+	return future
+}
+
+// SubmitContextFunc 以ctx派生出带timeout期限的context并把它传给fn执行，timeout为0时只使用ctx。
+// 与SubmitWithContext一样，context在开始执行前结束时fn不会执行；开始执行后需要fn自己检查context。
+// Future完成后派生的context随即被取消。
+func (es *Executors) SubmitContextFunc(ctx context.Context, timeout time.Duration, fn func(context.Context) (interface{}, error)) *Future {
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	future := es.newFuture(func() (interface{}, error) {
+		return fn(ctx)
+	})
+	future.ctx = ctx
+	future.onDone = func(*Future) { cancel() }
+	es.enqueue(future)
+	return future
+}
+
+// SubmitWithFallback 与Submit类似，但队列已满、已封闭或Executors已停止时不阻塞、也不报错，
+// 而是在另一个goroutine里执行fallback，用它的结果完成Future。已停止且设置了继任者时先交给继任者。
+// fallback同样经过Use设置的中间件；死信处理函数收到的仍是callable。
+func (es *Executors) SubmitWithFallback(callable Callable, fallback Callable) *Future {
+	future := es.newFuture(callable)
+	es.offerOrFallback(future, es.wrap(fallback))
+	return future
+}
+
+func (es *Executors) offerOrFallback(future *Future, fallback Callable) {
+	es.stopMu.RLock()
+	running := atomic.LoadInt32(&es.running) == 1
+	atomic.AddInt32(&es.pendingNum, 1)
+	if running && atomic.LoadInt32(&es.sealed) == 0 {
+		select {
+		case es.futureQ <- future:
+			es.stopMu.RUnlock()
+			return
+		default:
+		}
+	}
+	es.stopMu.RUnlock()
+	if next := es.getSuccessor(); !running && next != nil {
+		atomic.AddInt32(&es.pendingNum, -1)
+		next.offerOrFallback(future, fallback)
+		return
+	}
+	future.fallback = fallback
+	go es.execute(future)
+}
+
+// SubmitLimited 提交一批Callable，但同一时刻最多有maxConcurrent个在排队或执行，
+// 其余的在前面的完成后才进入队列，避免一批任务占满整个Executors。
+// 返回的Future与callables一一对应。
+func (es *Executors) SubmitLimited(callables []Callable, maxConcurrent int) []*Future {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	futures := make([]*Future, len(callables))
+	var next int32 = int32(maxConcurrent) - 1
+	onDone := func(*Future) {
+		if i := atomic.AddInt32(&next, 1); int(i) < len(futures) {
+			// onDone在工作goroutine中执行，队列满时入队会阻塞，因此交给新的goroutine
+			go func() {
+				es.enqueue(futures[i])
+				atomic.AddInt32(&es.scheduledNum, -1)
+			}()
+		}
+	}
+	for i, callable := range callables {
+		futures[i] = es.newFuture(callable)
+		futures[i].onDone = onDone
+	}
+	if held := len(futures) - maxConcurrent; held > 0 {
+		atomic.AddInt32(&es.scheduledNum, int32(held))
+	}
+	for i := 0; i < len(futures) && i < maxConcurrent; i++ {
+		es.enqueue(futures[i])
+	}
+	return futures
+}
+
+// SubmitChain 依次执行stages：每一步以上一步的结果（第一步为nil）构造Callable并提交，
+// 返回的Future得到最后一步的结果。任何一步出错、panic或超时都会中止后续步骤，
+// 返回的Future得到同样的结果。取消返回的Future后，正在执行的步骤照常结束，但不会再开始下一步。
+func (es *Executors) SubmitChain(stages ...func(prev interface{}) Callable) *Future {
+	chain := newFuture(nil)
+	if len(stages) == 0 {
+		atomic.StoreInt32(&chain.state, futureRunning)
+		chain.complete(nil, nil, nil)
+		return chain
+	}
+	var current atomic.Value // *Future，当前步骤
+	// 整条链在完成前计为一个尚未处理完的Future，步骤之间的间隙也不会被Quiesce当作空闲
+	atomic.AddInt32(&es.scheduledNum, 1)
+	chain.onDone = func(*Future) {
+		atomic.AddInt32(&es.scheduledNum, -1)
+		if stage, _ := current.Load().(*Future); stage != nil {
+			stage.Cancel()
+		}
+	}
+	var submit func(i int, prev interface{})
+	submit = func(i int, prev interface{}) {
+		stage := es.newInternalFuture(func() (interface{}, error) {
+			return stages[i](prev)()
+		})
+		stage.onDone = func(f *Future) {
+			if f.err != nil || f.exception != nil || f.timedOut || i == len(stages)-1 {
+				if atomic.CompareAndSwapInt32(&chain.state, futurePending, futureRunning) {
+					chain.copyFrom(f)
+				}
+				return
+			}
+			// onDone在工作goroutine中执行，队列满时入队会阻塞，因此交给新的goroutine
+			go submit(i+1, f.ret)
+		}
+		current.Store(stage)
+		if chain.IsDone() {
+			return // 已被取消
+		}
+		es.enqueue(stage)
+	}
+	submit(0, nil)
+	return chain
+}
+
+// SubmitBatchFunc 按顺序提交一批普通函数，便于迁移已有的[]func() (interface{}, error)。
+// Executors已停止时对应位置是以ErrStopped（或ErrTerminated）完成的Future，同时返回该错误。
+func (es *Executors) SubmitBatchFunc(fns []func() (interface{}, error)) ([]*Future, error) {
+	futures := make([]*Future, len(fns))
+	var err error
+	for i, fn := range fns {
+		futures[i] = es.newFuture(fn)
+		if e := es.enqueue(futures[i]); e != nil {
+			err = e
+		}
+	}
+	return futures, err
+}
+
+// Pipe 为in中的每个值提交一个执行fn的Callable，按完成顺序在返回的chan中送出Future；
+// in关闭且所有Callable都完成后关闭返回的chan。队列满时Pipe停止从in读取，从而把背压传给生产者。
+// ctx结束后（包括正在等待入队时）不再读取in，已提交但尚未开始的Callable会被跳过并得到ctx.Err()；
+// 此后送出的Future可能被丢弃，调用者不必读完out，返回的chan仍会被关闭。
+func (es *Executors) Pipe(ctx context.Context, in <-chan interface{}, fn func(context.Context, interface{}) (interface{}, error)) <-chan *Future {
+	out := make(chan *Future)
+	go func() {
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			close(out)
+		}()
+		onDone := func(f *Future) {
+			// 不能阻塞执行Callable的goroutine；ctx结束后调用者可能不再读取out，此时丢弃f
+			go func() {
+				defer wg.Done()
+				select {
+				case out <- f:
+				case <-ctx.Done():
+				}
+			}()
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				future := es.newFuture(func() (interface{}, error) {
+					return fn(ctx, v)
+				})
+				future.ctx = ctx
+				future.onDone = onDone
+				wg.Add(1)
+				if err := es.enqueueContext(ctx, future); err != nil && err == ctx.Err() {
+					// 等待入队时ctx结束，future还没有被完成
+					if atomic.CompareAndSwapInt32(&future.state, futurePending, futureCancelled) {
+						future.complete(nil, err, nil)
+					}
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// SubmitAllOrReject 要么整批提交callables，要么一个都不提交：队列剩余容量不足时返回ErrBatchTooLarge，
+// 已停止时返回ErrStopped或ErrTerminated，两种情况下都不会有Callable被执行。
+// 检查容量与入队期间持有Stop用的写锁，其他提交会短暂等待。
+func (es *Executors) SubmitAllOrReject(callables []Callable) ([]*Future, error) {
+	futures := make([]*Future, len(callables))
+	for i, callable := range callables {
+		futures[i] = es.newFuture(callable)
+	}
+	es.stopMu.Lock()
+	defer es.stopMu.Unlock()
+	if atomic.LoadInt32(&es.running) == 0 {
+		if es.State() == StateTerminated {
+			return nil, ErrTerminated
+		}
+		return nil, ErrStopped
+	}
+	if atomic.LoadInt32(&es.sealed) == 1 {
+		return nil, ErrSealed
+	}
+	// 持有写锁时没有其他入队者，队列只会被goroutine取走而变短
+	if cap(es.futureQ)-len(es.futureQ) < len(futures) {
+		return nil, ErrBatchTooLarge
+	}
+	atomic.AddInt32(&es.pendingNum, int32(len(futures)))
+	for _, future := range futures {
+		es.futureQ <- future
+	}
+	return futures, nil
+}
+
+// InvokeAll 提交所有callables并等待每一个都有结果后返回，返回的Future与callables一一对应且都已完成，
+// 可逐个用TryGet区分成功、出错、异常和被取消。ctx在中途结束时不再继续入队，尚未开始的Callable不再执行，
+// 其Future得到ErrCancelled，已经开始的仍会等其执行完毕，此时返回ctx.Err()。
+func (es *Executors) InvokeAll(ctx context.Context, callables []Callable) ([]*Future, error) {
+	futures := make([]*Future, len(callables))
+	for i, callable := range callables {
+		// 不设置future.ctx，统一由下面的Cancel跳过尚未开始的Callable，使其都得到ErrCancelled
+		futures[i] = es.newFuture(callable)
+	}
+	for _, future := range futures {
+		// 队列满时入队会阻塞，ctx结束后不再入队，剩下的由下面的Cancel完成
+		if es.enqueueContext(ctx, future); ctx.Err() != nil {
+			break
+		}
+	}
+	var err error
+	for _, future := range futures {
+		if err == nil {
+			select {
+			case <-future.done:
+				continue
+			case <-ctx.Done():
+				err = ctx.Err()
+				for _, f := range futures {
+					f.Cancel()
+				}
+			}
+		}
+		<-future.done
+	}
+	return futures, err
+}
+
+// SubmitMulti 提交一个返回多个命名结果的函数，结果可用Future.GetField逐个取出。
+func (es *Executors) SubmitMulti(fn func() (map[string]interface{}, error)) *Future {
+	return es.Submit(func() (interface{}, error) {
+		fields, err := fn()
+		if err != nil {
+			return nil, err
+		}
+		return fields, nil
+	})
+}
+
+// SubmitStream 提交一批Callable，返回的chan按完成顺序（而非提交顺序）送出各自的Future，
+// 全部完成后关闭。chan的缓冲等于len(callables)，因此不会阻塞执行Callable的goroutine。
+func (es *Executors) SubmitStream(callables []Callable) <-chan *Future {
+	stream := make(chan *Future, len(callables))
+	if len(callables) == 0 {
+		close(stream)
+		return stream
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(callables))
+	onDone := func(f *Future) {
+		stream <- f
+		wg.Done()
+	}
+	for _, callable := range callables {
+		future := es.newFuture(callable)
+		future.onDone = onDone
+		es.enqueue(future)
+	}
+	go func() {
+		wg.Wait()
+		close(stream)
+	}()
+	return stream
+}
+
+type State string
+
+const (
+	StateRunning    State = "running"
+	StateStopped    State = "stopped"    // 已Stop，仍有goroutine未退出
+	StateTerminated State = "terminated" // 已Stop，所有goroutine都已退出
+)
+
+// HealthStatus 是Executors状态的一次快照，字段可直接序列化为JSON。
+type HealthStatus struct {
+	State            State   `json:"state"`
+	GoNum            int32   `json:"goNum"`
+	BusyNum          int32   `json:"busyNum"`
+	QueueLen         int     `json:"queueLen"`
+	QueueUtilization float64 `json:"queueUtilization"`
+	GoUtilization    float64 `json:"goUtilization"`
+	WaitingNum       int32   `json:"waitingNum"`
+	Healthy          bool    `json:"healthy"`
+}
+
+// String 返回便于打印到日志的多行报告。
+func (h HealthStatus) String() string {
+	return fmt.Sprintf("状态: %s\n健康: %t\ngoroutine: %d (忙碌 %d, 使用率 %.1f%%)\n队列: %d (使用率 %.1f%%)\n等待结果: %d",
+		h.State, h.Healthy, h.GoNum, h.BusyNum, h.GoUtilization*100, h.QueueLen, h.QueueUtilization*100, h.WaitingNum)
+}
+
+func (es *Executors) State() State {
+	switch {
+	case atomic.LoadInt32(&es.running) == 1:
+		return StateRunning
+	case es.GetGoNum() > 0:
+		return StateStopped
+	default:
+		return StateTerminated
+	}
+}
+
+// Health 返回当前的健康状态，只读取几个原子变量，可以频繁调用。
+// 运行中且队列与goroutine的使用率都不超过config中的阈值时视为健康。
+func (es *Executors) Health() HealthStatus {
+	h := HealthStatus{
+		State:      es.State(),
+		GoNum:      es.GetGoNum(),
+		BusyNum:    es.GetBusyNum(),
+		QueueLen:   len(es.futureQ),
+		WaitingNum: es.GetWaitingNum(),
+	}
+	if c := cap(es.futureQ); c > 0 {
+		h.QueueUtilization = float64(h.QueueLen) / float64(c)
+	}
+	if h.GoNum > 0 {
+		h.GoUtilization = float64(h.BusyNum) / float64(h.GoNum)
+	}
+	h.Healthy = h.State == StateRunning &&
+		h.QueueUtilization <= config.HealthyQueueUtilization() &&
+		h.GoUtilization <= config.HealthyGoUtilization()
+	return h
+}
+
+// HealthHTTPHandler 以JSON返回Health()，可挂在例如/metrics/executor上，只依赖标准库。
+// 不健康时状态码为503，便于直接用作就绪探针。
+func (es *Executors) HealthHTTPHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := es.Health()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if !h.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(h)
+	}
+}
+
+var expvarMu sync.Mutex
+
+// PublishExpvar 把Health()以name注册到expvar，可通过/debug/vars查看。
+// expvar遇到重名会panic，这里改为返回错误。
+func (es *Executors) PublishExpvar(name string) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar %q 已经注册过了", name)
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return es.Health()
+	}))
+	return nil
+}
+
+// ExportCSV 每隔interval向w追加一行CSV格式的状态（先写一次表头），每行都立即Flush，
+// 便于tail -f或导入表格分析。Executors停止后结束。interval不为正时返回错误，不启动导出。
+func (es *Executors) ExportCSV(w io.Writer, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("ExportCSV的间隔必须为正数: %v", interval)
+	}
+	go func() {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "state", "goNum", "busyNum", "queueLen", "pendingNum", "overdueNum"})
+		cw.Flush()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-es.stopped:
+				return
+			case now := <-ticker.C:
+				h := es.Health()
+				cw.Write([]string{
+					now.Format(time.RFC3339),
+					string(h.State),
+					strconv.Itoa(int(h.GoNum)),
+					strconv.Itoa(int(h.BusyNum)),
+					strconv.Itoa(h.QueueLen),
+					strconv.Itoa(int(atomic.LoadInt32(&es.pendingNum))),
+					strconv.FormatInt(es.GetOverdueNum(), 10),
+				})
+				cw.Flush()
+				if err := cw.Error(); err != nil {
+					fmt.Println("写入CSV失败:", err)
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Config 是Executors实际生效的配置，包括config包中的默认值和运行时通过SetXxx设置的值。
+type Config struct {
+	DefaultGoroutinesNum  int32
+	MaxGoroutinesNum      int32
+	ScaleUpQueueThreshold int
+	NonCoreKeepAliveTime  time.Duration
+	BatchSize             int
+	QueueCapacity         int
+	RecoverPanics         bool
+	MaxExecutionTime      time.Duration
+	SlowThreshold         time.Duration
+	OfferTimeout          time.Duration
+	WarnUnreadFutures     bool
+	Sealed                bool
+}
+
+// Config 返回当前生效配置的副本，修改它不会影响Executors。
+func (es *Executors) Config() Config {
+	es.configMu.RLock()
+	defer es.configMu.RUnlock()
+	return Config{
+		DefaultGoroutinesNum:  config.DefaultGoroutinesNum(),
+		MaxGoroutinesNum:      config.MaxGoroutinesNum(),
+		ScaleUpQueueThreshold: config.ScaleUpQueueThreshold(),
+		NonCoreKeepAliveTime:  config.NonCoreKeepAliveTime(),
+		BatchSize:             config.BatchSize(),
+		QueueCapacity:         cap(es.futureQ),
+		RecoverPanics:         atomic.LoadInt32(&es.recoverPanics) == 1,
+		MaxExecutionTime:      time.Duration(atomic.LoadInt64(&es.maxExecution)),
+		SlowThreshold:         time.Duration(atomic.LoadInt64(&es.slowThreshold)),
+		OfferTimeout:          time.Duration(atomic.LoadInt64(&es.offerTimeout)),
+		WarnUnreadFutures:     atomic.LoadInt32(&es.warnUnread) == 1,
+		Sealed:                atomic.LoadInt32(&es.sealed) == 1,
+	}
+}
+
+// Reconfigure 一次性应用cfg中可以在运行时修改的配置：RecoverPanics、MaxExecutionTime、
+// SlowThreshold、OfferTimeout、WarnUnreadFutures和Sealed。其余字段来自config包或在创建时确定，
+// 与当前值不同时返回错误；时长为负时也返回错误。出错时不做任何修改。
+// 通常先用Config()取得当前配置，修改后再传入。
+func (es *Executors) Reconfigure(cfg Config) error {
+	es.configMu.Lock()
+	defer es.configMu.Unlock()
+	cur := Config{
+		DefaultGoroutinesNum:  config.DefaultGoroutinesNum(),
+		MaxGoroutinesNum:      config.MaxGoroutinesNum(),
+		ScaleUpQueueThreshold: config.ScaleUpQueueThreshold(),
+		NonCoreKeepAliveTime:  config.NonCoreKeepAliveTime(),
+		BatchSize:             config.BatchSize(),
+		QueueCapacity:         cap(es.futureQ),
+	}
+	switch {
+	case cfg.DefaultGoroutinesNum != cur.DefaultGoroutinesNum,
+		cfg.MaxGoroutinesNum != cur.MaxGoroutinesNum,
+		cfg.ScaleUpQueueThreshold != cur.ScaleUpQueueThreshold,
+		cfg.NonCoreKeepAliveTime != cur.NonCoreKeepAliveTime,
+		cfg.BatchSize != cur.BatchSize,
+		cfg.QueueCapacity != cur.QueueCapacity:
+		return errors.New("goroutine数、队列容量等配置不能在运行时修改！")
+	case cfg.MaxExecutionTime < 0, cfg.SlowThreshold < 0, cfg.OfferTimeout < 0:
+		return fmt.Errorf("时长不能为负: MaxExecutionTime=%v SlowThreshold=%v OfferTimeout=%v",
+			cfg.MaxExecutionTime, cfg.SlowThreshold, cfg.OfferTimeout)
+	}
+	es.SetRecoverPanics(cfg.RecoverPanics)
+	es.SetMaxExecutionTime(cfg.MaxExecutionTime)
+	es.SetSlowThreshold(cfg.SlowThreshold)
+	es.SetOfferTimeout(cfg.OfferTimeout)
+	es.SetWarnUnreadFutures(cfg.WarnUnreadFutures)
+	if cfg.Sealed {
+		es.Seal()
+	} else {
+		es.Unseal()
+	}
+	return nil
+}
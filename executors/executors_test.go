@@ -0,0 +1,1288 @@
+package executors
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/linkerlin/GoExecutors/config"
+)
+
+// occupyAll 让所有goroutine都阻塞在一个Callable中，之后提交的Callable会停留在队列里。
+// 返回的函数放行这些Callable。
+func occupyAll(es *Executors) (release func()) {
+	block := make(chan struct{})
+	for es.GetBusyNum() < es.GetGoNum() {
+		if atomic.LoadInt32(&es.pendingNum) < es.GetGoNum() {
+			es.Go(func() { <-block })
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return func() { close(block) }
+}
+
+func returning(v interface{}) Callable {
+	return func() (interface{}, error) { return v, nil }
+}
+
+func failing(err error) Callable {
+	return func() (interface{}, error) { return nil, err }
+}
+
+func TestSubmitConcurrentWithStop(t *testing.T) {
+	es := NewExecutors()
+	var wg sync.WaitGroup
+	futures := make(chan *Future, 1000)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				futures <- es.Submit(returning(j))
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	es.Stop()
+	wg.Wait()
+	close(futures)
+	for f := range futures {
+		_, timeoutError, err, _ := f.GetResult(time.Second)
+		if timeoutError != nil {
+			t.Fatal("Stop前后提交的Future没有完成")
+		}
+		var stopped ErrorStopped
+		if err != nil && !errors.As(err, &stopped) {
+			t.Fatal("意外的错误:", err)
+		}
+	}
+}
+
+// newExecutorsWithoutGo 创建一个没有goroutine的Executors，提交的Callable只会停留在队列中。
+func newExecutorsWithoutGo(capacity int) *Executors {
+	return &Executors{futureQ: make(FutureQ, capacity), running: 1, recoverPanics: 1, stopped: make(chan struct{})}
+}
+
+func TestStopWhileSubmitBlocked(t *testing.T) {
+	es := newExecutorsWithoutGo(1)
+	es.Submit(returning(1))
+	parked := make(chan *Future)
+	go func() { parked <- es.Submit(returning(2)) }()
+	time.Sleep(20 * time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		es.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop被阻塞在入队上的提交卡住了")
+	}
+	var stoppedErr ErrorStopped
+	select {
+	case f := <-parked:
+		if !errors.As(f.Error(), &stoppedErr) {
+			t.Fatal("阻塞的提交应得到ErrStopped或ErrTerminated:", f.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("阻塞的提交没有返回")
+	}
+	submitted := make(chan *Future)
+	go func() { submitted <- es.Submit(returning(3)) }()
+	select {
+	case f := <-submitted:
+		if !errors.As(f.Error(), &stoppedErr) {
+			t.Fatal(f.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stop之后的提交没有返回")
+	}
+}
+
+func TestStopFailsQueuedFutures(t *testing.T) {
+	es := NewExecutors()
+	release := occupyAll(es)
+	f := es.Submit(returning(1))
+	es.Stop()
+	release()
+	if _, timeoutError, err, _ := f.GetResult(time.Second); timeoutError != nil || err != ErrStopped {
+		t.Fatal(timeoutError, err)
+	}
+	_, _, err, _ := es.Submit(returning(1)).GetResult(time.Second)
+	var stopped ErrorStopped
+	if !errors.As(err, &stopped) {
+		t.Fatal("Stop之后提交应得到ErrStopped或ErrTerminated:", err)
+	}
+}
+
+func TestSubmitStreamCompletionOrder(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	sleepy := func(d time.Duration) Callable {
+		return func() (interface{}, error) {
+			time.Sleep(d)
+			return d, nil
+		}
+	}
+	stream := es.SubmitStream([]Callable{sleepy(60 * time.Millisecond), sleepy(0), sleepy(30 * time.Millisecond)})
+	var got []interface{}
+	for f := range stream {
+		got = append(got, f.Value())
+	}
+	want := []interface{}{time.Duration(0), 30 * time.Millisecond, 60 * time.Millisecond}
+	if len(got) != len(want) {
+		t.Fatal(got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatal("应按完成顺序送出:", got)
+		}
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	_, _, err, exception := es.Submit(func() (interface{}, error) { panic("boom") }).GetResult(time.Second)
+	if err != nil || exception != "boom" {
+		t.Fatal(err, exception)
+	}
+}
+
+func TestRecoverPanicsOff(t *testing.T) {
+	if os.Getenv("EXECUTORS_CRASH") == "1" {
+		es := NewExecutors()
+		es.SetRecoverPanics(false)
+		es.Submit(func() (interface{}, error) { panic("boom") }).GetResult(time.Second)
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRecoverPanicsOff$")
+	cmd.Env = append(os.Environ(), "EXECUTORS_CRASH=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); !errors.As(err, &exitErr) || !bytes.Contains(stderr.Bytes(), []byte("panic: boom")) {
+		t.Fatal("关闭捕获后panic应终止进程:", err, stderr.String())
+	}
+}
+
+func TestCancelByKey(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	release := occupyAll(es)
+	a := []*Future{es.SubmitWithKey("a", returning(1)), es.SubmitWithKey("a", returning(2)), es.SubmitWithKey("a", returning(3))}
+	b := es.SubmitWithKey("b", returning(4))
+	if n := es.CancelByKey("a"); n != 3 {
+		t.Fatal("应取消3个:", n)
+	}
+	release()
+	for _, f := range a {
+		if _, _, err, _ := f.GetResult(time.Second); err != ErrCancelled {
+			t.Fatal(err)
+		}
+	}
+	if ret, _, _, _ := b.GetResult(time.Second); ret != 4 {
+		t.Fatal(ret)
+	}
+	if n := es.CancelByKey("a"); n != 0 {
+		t.Fatal(n)
+	}
+}
+
+func TestGetOrSubmitRunsOnce(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	var runs int32
+	callable := func() (interface{}, error) {
+		atomic.AddInt32(&runs, 1)
+		time.Sleep(20 * time.Millisecond)
+		return "v", nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ret, _, _, _ := es.GetOrSubmit("k", callable, time.Minute).GetResult(time.Second); ret != "v" {
+				t.Error(ret)
+			}
+		}()
+	}
+	wg.Wait()
+	if runs != 1 {
+		t.Fatal("callable应只执行一次:", runs)
+	}
+}
+
+func TestGetOrSubmitDoesNotCacheFailures(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	f := es.GetOrSubmit("k", failing(errors.New("e")), time.Minute)
+	f.GetResult(time.Second)
+	if es.GetOrSubmit("k", returning(1), time.Minute) == f {
+		t.Fatal("出错的结果不应被缓存")
+	}
+}
+
+func TestSubmitIdempotentReplaysFailures(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	var runs int32
+	callable := func() (interface{}, error) {
+		atomic.AddInt32(&runs, 1)
+		return nil, errors.New("e")
+	}
+	f := es.SubmitIdempotent("t", callable, 50*time.Millisecond)
+	f.GetResult(time.Second)
+	if _, _, err, _ := es.SubmitIdempotent("t", callable, 50*time.Millisecond).GetResult(time.Second); err == nil || runs != 1 {
+		t.Fatal("window内应重放之前的错误:", err, runs)
+	}
+	time.Sleep(100 * time.Millisecond)
+	es.SubmitIdempotent("t", callable, 50*time.Millisecond).GetResult(time.Second)
+	if runs != 2 {
+		t.Fatal("window之后应重新执行:", runs)
+	}
+}
+
+func TestSubmitAllOrRejectNoPartialAdmission(t *testing.T) {
+	es := NewExecutors()
+	var runs int32
+	callables := make([]Callable, cap(es.futureQ)+1)
+	for i := range callables {
+		callables[i] = func() (interface{}, error) {
+			atomic.AddInt32(&runs, 1)
+			return nil, nil
+		}
+	}
+	if futures, err := es.SubmitAllOrReject(callables); err != ErrBatchTooLarge || futures != nil {
+		t.Fatal(futures, err)
+	}
+	futures, err := es.SubmitAllOrReject(callables[:10])
+	if err != nil || len(futures) != 10 {
+		t.Fatal(futures, err)
+	}
+	for _, f := range futures {
+		f.GetResult(time.Second)
+	}
+	es.Stop()
+	if _, err := es.SubmitAllOrReject(callables[:1]); err != ErrStopped && err != ErrTerminated {
+		t.Fatal(err)
+	}
+	if runs != 10 {
+		t.Fatal("被拒绝的Callable不应执行:", runs)
+	}
+}
+
+func TestInvokeAllCancelled(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	release := occupyAll(es)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	futures, err := es.InvokeAll(ctx, []Callable{returning(1), returning(2), returning(3)})
+	release()
+	if err != context.Canceled {
+		t.Fatal(err)
+	}
+	for _, f := range futures {
+		if f.Error() != ErrCancelled {
+			t.Fatal("尚未开始的Callable应得到ErrCancelled:", f.Error())
+		}
+	}
+}
+
+func TestInvokeAllCancelledWhileEnqueueing(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	block := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	var lateStarts int32
+	callables := make([]Callable, 3*cap(es.futureQ)+3*int(es.GetGoNum()))
+	for i := range callables {
+		callables[i] = func() (interface{}, error) {
+			if ctx.Err() != nil {
+				atomic.AddInt32(&lateStarts, 1)
+			}
+			<-block
+			return nil, nil
+		}
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+		time.Sleep(100 * time.Millisecond)
+		close(block)
+	}()
+	futures, err := es.InvokeAll(ctx, callables)
+	if err != context.Canceled {
+		t.Fatal(err)
+	}
+	cancelled := 0
+	for _, f := range futures {
+		switch f.Error() {
+		case ErrCancelled:
+			cancelled++
+		case nil:
+		default:
+			t.Fatal(f.Error())
+		}
+	}
+	if lateStarts != 0 || cancelled < cap(es.futureQ) {
+		t.Fatal("ctx结束后不应再有Callable入队执行:", lateStarts, cancelled)
+	}
+}
+
+func TestDeadLetterPanicCalledOnce(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	var calls int32
+	es.SetDeadLetter(func(Callable, error) {
+		atomic.AddInt32(&calls, 1)
+		panic("dead letter")
+	})
+	_, _, err, exception := es.Submit(failing(errors.New("e"))).GetResult(time.Second)
+	if err == nil || exception != nil {
+		t.Fatal(err, exception)
+	}
+	if calls != 1 {
+		t.Fatal("死信处理函数应只调用一次:", calls)
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	mapped := errors.New("mapped")
+	es.SetPanicHandler(func(interface{}) error { return mapped })
+	if _, _, err, _ := es.Submit(func() (interface{}, error) { panic("boom") }).GetResult(time.Second); err != mapped {
+		t.Fatal(err)
+	}
+	es.SetPanicHandler(func(interface{}) error { panic("handler") })
+	if _, _, _, exception := es.Submit(func() (interface{}, error) { panic("boom") }).GetResult(time.Second); exception != "boom" {
+		t.Fatal("handler本身panic时应作为异常交给Future:", exception)
+	}
+}
+
+func TestErrorClassifier(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	transient, permanent := errors.New("transient"), errors.New("permanent")
+	es.SetErrorClassifier(func(err error) ErrorClass {
+		switch err {
+		case transient:
+			return ErrorTransient
+		case permanent:
+			return ErrorPermanent
+		}
+		panic("分类函数中的panic应被捕获")
+	})
+	for _, err := range []error{transient, transient, permanent, errors.New("other")} {
+		f := es.Submit(failing(err))
+		_, _, got, exception := f.GetResult(time.Second)
+		if got != err || exception != nil {
+			t.Fatal(got, exception)
+		}
+		if err == permanent && f.Class() != ErrorPermanent {
+			t.Fatal(f.Class())
+		}
+	}
+	if es.GetErrorNum(ErrorTransient) != 2 || es.GetErrorNum(ErrorPermanent) != 1 || es.GetErrorNum(ErrorUnknown) != 1 {
+		t.Fatal(es.GetErrorNum(ErrorTransient), es.GetErrorNum(ErrorPermanent), es.GetErrorNum(ErrorUnknown))
+	}
+}
+
+func TestUnreadWarningSkipsInternalFutures(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	es.SetWarnUnreadFutures(true)
+	for i := 0; i < 5; i++ {
+		es.Execute(failing(errors.New("e")))
+	}
+	es.SubmitChain(func(interface{}) Callable { return failing(errors.New("e")) }).GetResult(time.Second)
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := es.GetUnreadErrorNum(); n != 0 {
+		t.Fatal("调用者拿不到的Future不应计入:", n)
+	}
+}
+
+func TestSubmitChain(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	inc := func(prev interface{}) Callable {
+		n, _ := prev.(int)
+		return returning(n + 1)
+	}
+	if ret, _, _, _ := es.SubmitChain(inc, inc, inc).GetResult(time.Second); ret != 3 {
+		t.Fatal(ret)
+	}
+	var runs int32
+	counted := func(interface{}) Callable {
+		atomic.AddInt32(&runs, 1)
+		return returning(nil)
+	}
+	boom := errors.New("boom")
+	fail := func(interface{}) Callable { return failing(boom) }
+	if _, _, err, _ := es.SubmitChain(inc, fail, counted).GetResult(time.Second); err != boom || runs != 0 {
+		t.Fatal("出错后应中止后续步骤:", err, runs)
+	}
+}
+
+func TestGetWaitingNum(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	block := make(chan struct{})
+	f := es.Submit(func() (interface{}, error) {
+		<-block
+		return nil, nil
+	})
+	for i := 0; i < 3; i++ {
+		go f.GetResult(time.Second)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := es.Health().WaitingNum; n != 3 {
+		t.Fatal(n)
+	}
+	close(block)
+	time.Sleep(20 * time.Millisecond)
+	if n := es.GetWaitingNum(); n != 0 {
+		t.Fatal(n)
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	cfg := es.Config()
+	cfg.SlowThreshold = time.Second
+	cfg.OfferTimeout = time.Millisecond
+	cfg.RecoverPanics = false
+	if err := es.Reconfigure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := es.Config(); got != cfg {
+		t.Fatal(got, cfg)
+	}
+	bad := cfg
+	bad.QueueCapacity++
+	bad.SlowThreshold = 0
+	if err := es.Reconfigure(bad); err == nil || es.Config().SlowThreshold != time.Second {
+		t.Fatal("出错时不应做任何修改:", err)
+	}
+}
+
+func TestAwaitTerminationProgress(t *testing.T) {
+	es := NewExecutors()
+	es.Go(func() { time.Sleep(100 * time.Millisecond) })
+	time.Sleep(10 * time.Millisecond)
+	es.Stop()
+	var reported []int
+	if !es.AwaitTerminationProgress(5*time.Second, 20*time.Millisecond, func(remaining int) {
+		reported = append(reported, remaining)
+	}) {
+		t.Fatal("等待超时")
+	}
+	if len(reported) == 0 || reported[0] != 1 {
+		t.Fatal(reported)
+	}
+}
+
+func TestExportCSVRejectsBadInterval(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	if err := es.ExportCSV(&bytes.Buffer{}, 0); err == nil {
+		t.Fatal("interval为0时应返回错误")
+	}
+}
+
+func TestSubmitAfterCancel(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	var runs int32
+	f := es.SubmitAfter(func() (interface{}, error) {
+		atomic.AddInt32(&runs, 1)
+		return nil, nil
+	}, 20*time.Millisecond)
+	if !f.Cancel() {
+		t.Fatal("应能取消")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if runs != 0 {
+		t.Fatal(runs)
+	}
+	if ret, _, _, _ := es.SubmitAfter(returning(1), time.Millisecond).GetResult(time.Second); ret != 1 {
+		t.Fatal(ret)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	in := make(chan interface{})
+	go func() {
+		for i := 0; i < 500; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	out := es.Pipe(context.Background(), in, func(_ context.Context, v interface{}) (interface{}, error) {
+		return v.(int) * 2, nil
+	})
+	seen := make(map[interface{}]bool)
+	for f := range out {
+		seen[f.Value()] = true
+	}
+	for i := 0; i < 500; i++ {
+		if !seen[i*2] {
+			t.Fatal("缺少结果:", i*2)
+		}
+	}
+}
+
+func TestPipeStopsWhileQueueFull(t *testing.T) {
+	es := newExecutorsWithoutGo(1)
+	in := make(chan interface{}, 3)
+	for i := 0; i < cap(in); i++ {
+		in <- i
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	out := es.Pipe(ctx, in, func(_ context.Context, v interface{}) (interface{}, error) { return v, nil })
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	es.Stop() // 没有goroutine，已入队的那个由Stop完成
+	for f := range out {
+		if err := f.Error(); err != context.Canceled && err != ErrStopped {
+			t.Fatal(err)
+		}
+	}
+	if len(in) != 1 {
+		t.Fatal("ctx结束后不应再读取in:", len(in))
+	}
+}
+
+func TestPipeReleasesSendersAfterCancel(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	in := make(chan interface{}, 20)
+	for i := 0; i < cap(in); i++ {
+		in <- i
+	}
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	out := es.Pipe(ctx, in, func(_ context.Context, v interface{}) (interface{}, error) { return v, nil })
+	<-out
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	if leaked := runtime.NumGoroutine() - before; leaked >= 5 {
+		t.Fatal("不读取out时发送的goroutine应退出:", leaked)
+	}
+}
+
+func TestQuiesceWaitsForHeldFutures(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	slow := func() (interface{}, error) {
+		time.Sleep(5 * time.Millisecond)
+		return nil, nil
+	}
+	futures := []*Future{es.SubmitAfter(slow, 30*time.Millisecond)}
+	futures = append(futures, es.SubmitLimited([]Callable{slow, slow, slow, slow, slow}, 1)...)
+	step := func(interface{}) Callable { return slow }
+	futures = append(futures, es.SubmitChain(step, step, step, step))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := es.Quiesce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range futures {
+		if !f.IsDone() {
+			t.Fatal("Quiesce返回时仍有Future未完成:", i)
+		}
+	}
+}
+
+func TestSubmitWithFallback(t *testing.T) {
+	es := newExecutorsWithoutGo(1)
+	defer es.Stop()
+	var wrapped int32
+	es.Use(func(next Callable) Callable {
+		return func() (interface{}, error) {
+			atomic.AddInt32(&wrapped, 1)
+			return next()
+		}
+	})
+	var deadLetter interface{}
+	es.SetDeadLetter(func(callable Callable, err error) { deadLetter, _ = callable() })
+	es.Submit(returning(0)) // 占满队列
+	f := es.SubmitWithFallback(returning("original"), failing(errors.New("degraded")))
+	if _, _, err, _ := f.GetResult(time.Second); err == nil || err.Error() != "degraded" {
+		t.Fatal("队列满时应以fallback的结果完成:", err)
+	}
+	if wrapped != 2 || deadLetter != "original" {
+		t.Fatal("fallback应经过中间件，死信应收到原来的Callable:", wrapped, deadLetter)
+	}
+}
+
+func TestSubmitWithFallbackPrefersSuccessor(t *testing.T) {
+	es, next := NewExecutors(), NewExecutors()
+	defer next.Stop()
+	es.SetSuccessor(next)
+	es.Stop()
+	if ret, _, _, _ := es.SubmitWithFallback(returning("next"), returning("fallback")).GetResult(time.Second); ret != "next" {
+		t.Fatal("已停止时应先交给继任者:", ret)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	ran := make(chan struct{})
+	if err := es.Execute(func() (interface{}, error) {
+		close(ran)
+		return nil, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("Execute提交的Callable没有执行")
+	}
+	es.Stop()
+	if err := es.Execute(returning(nil)); err == nil {
+		t.Fatal("停止后Execute应返回错误")
+	}
+}
+
+func BenchmarkSubmit(b *testing.B) {
+	es := NewExecutors()
+	defer es.Stop()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		es.Submit(returning(nil)).GetResult(time.Second)
+	}
+}
+
+func BenchmarkExecute(b *testing.B) {
+	es := NewExecutors()
+	defer es.Stop()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		es.Execute(returning(nil))
+	}
+	es.Quiesce(context.Background())
+}
+
+func TestExecuteBatchCompletesEachFuture(t *testing.T) {
+	es := newExecutorsWithoutGo(3)
+	ok := es.Submit(returning(1))
+	bad := es.Submit(failing(errors.New("bad")))
+	boom := es.Submit(func() (interface{}, error) { panic("boom") })
+	es.executeBatch(config.BatchSize())
+	if ret, _, err, exception := ok.GetResult(time.Second); ret != 1 || err != nil || exception != nil {
+		t.Fatal(ret, err, exception)
+	}
+	if _, _, err, _ := bad.GetResult(time.Second); err == nil || err.Error() != "bad" {
+		t.Fatal(err)
+	}
+	if _, _, _, exception := boom.GetResult(time.Second); exception == nil {
+		t.Fatal("panic应只作为该Future的异常报告")
+	}
+	if n := atomic.LoadInt32(&es.pendingNum); n != 0 {
+		t.Fatal("批量执行后pendingNum应归零:", n)
+	}
+}
+
+// benchmarkBatch 模拟工作goroutine的循环：每轮select一次，再不阻塞地执行至多batch-1个Future。
+func benchmarkBatch(b *testing.B, batch int) {
+	es := newExecutorsWithoutGo(config.BatchSize())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for len(es.futureQ) < cap(es.futureQ) {
+			es.Execute(returning(nil))
+		}
+		b.StartTimer()
+		for len(es.futureQ) > 0 {
+			select {
+			case future := <-es.futureQ:
+				es.execute(future)
+				es.executeBatch(batch - 1)
+			case <-time.After(config.NonCoreKeepAliveTime()):
+			}
+		}
+	}
+}
+
+func BenchmarkBatch1(b *testing.B)  { benchmarkBatch(b, 1) }
+func BenchmarkBatch16(b *testing.B) { benchmarkBatch(b, 16) }
+
+func TestHealth(t *testing.T) {
+	es := NewExecutors()
+	if h := es.Health(); !h.Healthy || h.State != StateRunning || h.GoNum == 0 {
+		t.Fatal("空闲的Executors应健康:", h)
+	}
+	es.Stop()
+	if h := es.Health(); h.Healthy || h.State == StateRunning {
+		t.Fatal("停止后不应健康:", h)
+	}
+	full := newExecutorsWithoutGo(2)
+	defer full.Stop()
+	full.Submit(returning(1))
+	full.Submit(returning(2))
+	if h := full.Health(); h.Healthy || h.QueueLen != 2 || h.QueueUtilization != 1 {
+		t.Fatal("队列满时不应健康:", h)
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	name := fmt.Sprint("executors_test_", time.Now().UnixNano()) // expvar是全局的，-count>1时不能重名
+	if err := es.PublishExpvar(name); err != nil {
+		t.Fatal(err)
+	}
+	var h HealthStatus
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &h); err != nil || h.State != StateRunning {
+		t.Fatal("expvar应输出Health()的JSON:", err, h)
+	}
+	if err := es.PublishExpvar(name); err == nil {
+		t.Fatal("重复注册应返回错误而不是panic")
+	}
+}
+
+func TestStopAfterIdle(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	block := make(chan struct{})
+	es.Go(func() { <-block })
+	es.StopAfterIdle(20 * time.Millisecond)
+	time.Sleep(60 * time.Millisecond)
+	if es.State() != StateRunning {
+		t.Fatal("仍有Callable在执行时不应停止")
+	}
+	close(block)
+	deadline := time.Now().Add(time.Second)
+	for es.State() == StateRunning {
+		if time.Now().After(deadline) {
+			t.Fatal("空闲超过idle后应自动停止")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStopNowFunc(t *testing.T) {
+	es := newExecutorsWithoutGo(3)
+	queued := []*Future{es.Submit(returning(1)), es.Submit(returning(2)), es.Submit(returning(3))}
+	var abandoned []*Future
+	es.StopNowFunc(func(f *Future) { abandoned = append(abandoned, f) })
+	if len(abandoned) != len(queued) {
+		t.Fatal("每个未执行的Future都应交给fn:", len(abandoned))
+	}
+	for i, f := range abandoned {
+		if f != queued[i] || f.Error() != ErrCancelled {
+			t.Fatal("应按队列顺序交出已取消的Future:", i, f.Error())
+		}
+	}
+}
+
+func TestSubmitWithContextCancelledWhileQueued(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	release := occupyAll(es)
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran int32
+	f := es.SubmitWithContext(ctx, func() (interface{}, error) {
+		atomic.StoreInt32(&ran, 1)
+		return nil, nil
+	})
+	cancel()
+	release()
+	if _, _, err, _ := f.GetResult(time.Second); err != context.Canceled {
+		t.Fatal("排队期间ctx被取消应得到ctx.Err():", err)
+	}
+	if atomic.LoadInt32(&ran) != 0 {
+		t.Fatal("ctx已取消的Callable不应执行")
+	}
+}
+
+func TestUseOrder(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	var mu sync.Mutex
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Callable) Callable {
+			return func() (interface{}, error) {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				return next()
+			}
+		}
+	}
+	es.Use(trace("outer"))
+	es.Use(trace("inner"))
+	if ret, _, _, _ := es.Submit(returning(1)).GetResult(time.Second); ret != 1 {
+		t.Fatal(ret)
+	}
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Fatal("先注册的中间件应在最外层:", order)
+	}
+}
+
+func TestHealthString(t *testing.T) {
+	h := HealthStatus{State: StateRunning, Healthy: true, GoNum: 4, BusyNum: 1, GoUtilization: 0.25, QueueLen: 5, QueueUtilization: 0.05, WaitingNum: 2}
+	want := "状态: running\n健康: true\ngoroutine: 4 (忙碌 1, 使用率 25.0%)\n队列: 5 (使用率 5.0%)\n等待结果: 2"
+	if got := h.String(); got != want {
+		t.Fatalf("报告格式不对:\n%s", got)
+	}
+	var decoded HealthStatus
+	if data, err := json.Marshal(h); err != nil || json.Unmarshal(data, &decoded) != nil || decoded != h {
+		t.Fatal("JSON报告应能还原:", err, decoded)
+	}
+}
+
+func TestGoexitInCallable(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	for i := 0; i < 5; i++ {
+		f := es.Submit(func() (interface{}, error) {
+			runtime.Goexit()
+			return nil, nil
+		})
+		if _, _, _, exception := f.GetResult(time.Second); exception == nil {
+			t.Fatal("调用Goexit的Callable应以异常完成")
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for es.GetGoNum() < config.DefaultGoroutinesNum() {
+		if time.Now().After(deadline) {
+			t.Fatal("退出的goroutine应被补上:", es.GetGoNum())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGo(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	var ran int32
+	ret, _, err, exception := es.Go(func() { atomic.StoreInt32(&ran, 1) }).GetResult(time.Second)
+	if ret != nil || err != nil || exception != nil || atomic.LoadInt32(&ran) != 1 {
+		t.Fatal(ret, err, exception, ran)
+	}
+	if _, _, _, exception := es.Go(func() { panic("boom") }).GetResult(time.Second); exception != "boom" {
+		t.Fatal("panic应作为异常交给Future:", exception)
+	}
+}
+
+func TestSuccessor(t *testing.T) {
+	es, next := NewExecutors(), NewExecutors()
+	defer next.Stop()
+	if err := es.SetSuccessor(next); err != nil {
+		t.Fatal(err)
+	}
+	if err := next.SetSuccessor(es); err == nil {
+		t.Fatal("形成环时应返回错误")
+	}
+	es.Stop()
+	if ret, _, err, _ := es.Submit(returning(1)).GetResult(time.Second); ret != 1 || err != nil {
+		t.Fatal("停止后的提交应转交给继任者:", ret, err)
+	}
+	es.SetSuccessor(nil)
+	var stoppedErr ErrorStopped
+	if _, _, err, _ := es.Submit(returning(1)).GetResult(time.Second); !errors.As(err, &stoppedErr) {
+		t.Fatal("取消继任后应得到ErrStopped或ErrTerminated:", err)
+	}
+}
+
+func TestSubmitLimitedMaxConcurrency(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	var running, peak int32
+	callables := make([]Callable, 20)
+	for i := range callables {
+		i := i
+		callables[i] = func() (interface{}, error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(2 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return i, nil
+		}
+	}
+	futures := es.SubmitLimited(callables, 3)
+	for i, f := range futures {
+		if ret, _, _, _ := f.GetResult(time.Second); ret != i {
+			t.Fatal("Future应与callables一一对应:", i, ret)
+		}
+	}
+	if peak := atomic.LoadInt32(&peak); peak > 3 {
+		t.Fatal("同时执行的Callable超过了maxConcurrent:", peak)
+	}
+}
+
+func TestSubmitLimitedDoesNotBlockWorkerOnFullQueue(t *testing.T) {
+	es := newExecutorsWithoutGo(1)
+	defer es.Stop()
+	futures := es.SubmitLimited([]Callable{returning(0), returning(1)}, 1)
+	first := <-es.futureQ
+	es.Submit(returning("filler")) // 占满队列，后续入队只能等待
+	executed := make(chan struct{})
+	go func() {
+		es.execute(first)
+		close(executed)
+	}()
+	select {
+	case <-executed:
+	case <-time.After(time.Second):
+		t.Fatal("完成回调在队列满时阻塞了执行它的goroutine")
+	}
+	es.execute(<-es.futureQ)
+	es.execute(<-es.futureQ)
+	if ret, _, _, _ := futures[1].GetResult(time.Second); ret != 1 {
+		t.Fatal(ret)
+	}
+}
+
+func TestStopWhenDone(t *testing.T) {
+	es := NewExecutors()
+	ctx, cancel := context.WithCancel(context.Background())
+	es.StopWhenDone(ctx, time.Second)
+	if es.State() != StateRunning {
+		t.Fatal("ctx结束前不应停止")
+	}
+	cancel()
+	deadline := time.Now().Add(2 * time.Second)
+	for es.State() != StateTerminated {
+		if time.Now().After(deadline) {
+			t.Fatal("ctx结束后应停止并等待goroutine退出:", es.State())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExecuteHooks(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	var before int32
+	after := make(chan interface{}, 2)
+	es.SetExecuteHooks(func(f *Future) {
+		if !f.IsDone() {
+			atomic.AddInt32(&before, 1)
+		}
+		panic("before中的panic应被忽略")
+	}, func(f *Future) {
+		ret, _, exception, done := f.TryGet()
+		if !done {
+			t.Error("after应在Future完成之后调用")
+		}
+		if exception != nil {
+			ret = exception
+		}
+		after <- ret
+	})
+	if ret, _, _, _ := es.Submit(returning(1)).GetResult(time.Second); ret != 1 {
+		t.Fatal(ret)
+	}
+	es.Submit(func() (interface{}, error) { panic("boom") })
+	for _, want := range []interface{}{1, "boom"} {
+		select {
+		case got := <-after:
+			if got != want {
+				t.Fatal(got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("after没有被调用:", want)
+		}
+	}
+	if atomic.LoadInt32(&before) != 2 {
+		t.Fatal("before应在执行前调用:", before)
+	}
+}
+
+func TestSubmitWithCleanupOnAllPaths(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	queued := newExecutorsWithoutGo(1)
+	defer queued.Stop()
+	paths := map[string]func(cleanup func()) *Future{
+		"成功": func(cleanup func()) *Future { return es.SubmitWithCleanup(returning(1), cleanup) },
+		"出错": func(cleanup func()) *Future { return es.SubmitWithCleanup(failing(errors.New("bad")), cleanup) },
+		"panic": func(cleanup func()) *Future {
+			return es.SubmitWithCleanup(func() (interface{}, error) { panic("boom") }, cleanup)
+		},
+		"取消": func(cleanup func()) *Future {
+			f := queued.SubmitWithCleanup(returning(1), cleanup)
+			f.Cancel()
+			<-queued.futureQ
+			return f
+		},
+		"停止": func(cleanup func()) *Future {
+			stopped := newExecutorsWithoutGo(1)
+			stopped.Stop()
+			return stopped.SubmitWithCleanup(returning(1), cleanup)
+		},
+	}
+	for name, submit := range paths {
+		var calls int32
+		f := submit(func() {
+			atomic.AddInt32(&calls, 1)
+			panic("cleanup中的panic应被忽略")
+		})
+		<-f.Done()
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt32(&calls) == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Fatal(name, "时cleanup应恰好调用一次:", n)
+		}
+	}
+}
+
+func TestSubmitBatchFunc(t *testing.T) {
+	es := NewExecutors()
+	fns := []func() (interface{}, error){returning(1), returning(2)}
+	futures, err := es.SubmitBatchFunc(fns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range futures {
+		if ret, _, _, _ := f.GetResult(time.Second); ret != i+1 {
+			t.Fatal(i, ret)
+		}
+	}
+	es.Stop()
+	var stoppedErr ErrorStopped
+	futures, err = es.SubmitBatchFunc(fns)
+	if !errors.As(err, &stoppedErr) || len(futures) != len(fns) || !errors.As(futures[1].Error(), &stoppedErr) {
+		t.Fatal("停止后应返回以ErrStopped完成的Future和该错误:", err)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	if err := es.ExportCSV(pw, 5*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	r := csv.NewReader(pr)
+	header, err := r.Read()
+	if err != nil || strings.Join(header, ",") != "time,state,goNum,busyNum,queueLen,pendingNum,overdueNum" {
+		t.Fatal(header, err)
+	}
+	for i := 0; i < 2; i++ {
+		row, err := r.Read()
+		if err != nil || len(row) != len(header) || row[1] != string(StateRunning) {
+			t.Fatal(row, err)
+		}
+		if _, err := time.Parse(time.RFC3339, row[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestErrStoppedThenErrTerminated(t *testing.T) {
+	es := NewExecutors()
+	block := make(chan struct{})
+	started := make(chan struct{})
+	es.Go(func() {
+		close(started)
+		<-block
+	})
+	<-started
+	es.Stop()
+	if err := es.Execute(returning(nil)); err != ErrStopped {
+		t.Fatal("仍有goroutine在收尾时应得到ErrStopped:", err)
+	}
+	close(block)
+	if !es.AwaitTermination(2 * time.Second) {
+		t.Fatal("goroutine没有退出")
+	}
+	if err := es.Execute(returning(nil)); err != ErrTerminated {
+		t.Fatal("goroutine全部退出后应得到ErrTerminated:", err)
+	}
+	var stoppedErr ErrorStopped
+	if !errors.As(ErrTerminated, &stoppedErr) {
+		t.Fatal("ErrTerminated应是ErrorStopped类型")
+	}
+}
+
+func TestHealthHTTPHandler(t *testing.T) {
+	es := NewExecutors()
+	handler := es.HealthHTTPHandler()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/metrics/executor", nil))
+	var h HealthStatus
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &h) != nil || !h.Healthy {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	es.Stop()
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/metrics/executor", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatal("不健康时应返回503:", rec.Code)
+	}
+}
+
+func TestSlowThreshold(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	es.SetSlowThreshold(10 * time.Millisecond)
+	es.Submit(returning(1)).GetResult(time.Second)
+	es.Go(func() { time.Sleep(20 * time.Millisecond) }).GetResult(time.Second)
+	deadline := time.Now().Add(time.Second)
+	for es.GetSlowNum() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := es.GetSlowNum(); n != 1 {
+		t.Fatal("只有超过阈值的Callable应计入:", n)
+	}
+}
+
+func TestOfferTimeout(t *testing.T) {
+	es := newExecutorsWithoutGo(1)
+	defer es.Stop()
+	es.SetOfferTimeout(10 * time.Millisecond)
+	es.Submit(returning(1)) // 占满队列
+	start := time.Now()
+	f := es.Submit(returning(2))
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond || elapsed > time.Second {
+		t.Fatal("队列满时应等待约OfferTimeout:", elapsed)
+	}
+	if _, _, err, _ := f.GetResult(time.Second); err != ErrQueueFull {
+		t.Fatal(err)
+	}
+	if err := es.Execute(returning(3)); err != ErrQueueFull {
+		t.Fatal(err)
+	}
+}
+
+func TestSubmitTo(t *testing.T) {
+	es := newExecutorsWithoutGo(1)
+	out := make(chan *Future) // 无缓冲且暂不读取，不应阻塞执行Callable的goroutine
+	if err := es.SubmitTo(returning(1), out); err != nil {
+		t.Fatal(err)
+	}
+	executed := make(chan struct{})
+	go func() {
+		es.execute(<-es.futureQ)
+		close(executed)
+	}()
+	select {
+	case <-executed:
+	case <-time.After(time.Second):
+		t.Fatal("out没有读取时阻塞了执行Callable的goroutine")
+	}
+	if f := <-out; f.Value() != 1 {
+		t.Fatal(f.Value())
+	}
+	es.Stop()
+	var stoppedErr ErrorStopped
+	if err := es.SubmitTo(returning(3), out); !errors.As(err, &stoppedErr) {
+		t.Fatal("停止后应返回ErrStopped或ErrTerminated:", err)
+	}
+	if f := <-out; !errors.As(f.Error(), &stoppedErr) {
+		t.Fatal("停止后Future同样应送到out:", f.Error())
+	}
+}
+
+func TestSeal(t *testing.T) {
+	es := newExecutorsWithoutGo(2)
+	defer es.Stop()
+	queued := es.Submit(returning(1))
+	es.Seal()
+	if _, _, err, _ := es.Submit(returning(2)).GetResult(time.Second); err != ErrSealed {
+		t.Fatal("封闭后的提交应得到ErrSealed:", err)
+	}
+	es.execute(<-es.futureQ)
+	if ret, _, _, _ := queued.GetResult(time.Second); ret != 1 {
+		t.Fatal("封闭前已入队的Callable应照常执行:", ret)
+	}
+	es.Unseal()
+	if err := es.Execute(returning(3)); err != nil {
+		t.Fatal("Unseal后应能重新提交:", err)
+	}
+}
+
+func TestSubmitContextFunc(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	f := es.SubmitContextFunc(context.Background(), 10*time.Millisecond, func(ctx context.Context) (interface{}, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			return nil, errors.New("fn收到的context应带有期限")
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if _, _, err, _ := f.GetResult(time.Second); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	var derived context.Context
+	f = es.SubmitContextFunc(context.Background(), time.Minute, func(ctx context.Context) (interface{}, error) {
+		derived = ctx
+		return 1, nil
+	})
+	f.GetResult(time.Second)
+	deadline := time.Now().Add(time.Second)
+	for derived.Err() == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if derived.Err() != context.Canceled {
+		t.Fatal("Future完成后派生的context应被取消:", derived.Err())
+	}
+}
+
+func TestSubmitIdempotentSharesInFlight(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	var runs int32
+	block := make(chan struct{})
+	callable := func() (interface{}, error) {
+		atomic.AddInt32(&runs, 1)
+		<-block
+		return "once", nil
+	}
+	first := es.SubmitIdempotent("token", callable, time.Minute)
+	second := es.SubmitIdempotent("token", callable, time.Minute)
+	if first != second {
+		t.Fatal("执行中的同一token应共享Future")
+	}
+	close(block)
+	if ret, _, _, _ := second.GetResult(time.Second); ret != "once" || atomic.LoadInt32(&runs) != 1 {
+		t.Fatal(ret, runs)
+	}
+}
@@ -0,0 +1,289 @@
+package executors
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	futurePending int32 = iota
+	futureRunning
+	futureCancelled
+)
+
+type ErrorCancelled string
+
+func (e ErrorCancelled) Error() string { return string(e) }
+
+const ErrCancelled = ErrorCancelled("Callable已被取消！")
+
+type Future struct {
+	state      int32
+	completed  int32
+	done       chan struct{}
+	ret        interface{}
+	err        error
+	exception  interface{}
+	callable   Callable
+	fallback   Callable        // 不为nil时代替callable执行，见SubmitWithFallback
+	ctx        context.Context // 可为nil；在Callable开始执行前被取消时跳过执行
+	deadline   time.Time       // 可为零值；超过后GetResult报告超时
+	timeout    time.Duration   // 设置deadline时的超时时长
+	timedOut   bool
+	class      ErrorClass    // err的分类，见SetErrorClassifier
+	guard      *unreadGuard  // 可为nil，见SetWarnUnreadFutures
+	onDone     func(*Future) // 完成后在执行Callable的goroutine中回调，不能阻塞
+	waitingNum *int32        // 可为nil，指向所属Executors的等待计数
+}
+
+func newFuture(callable Callable) *Future {
+	return &Future{done: make(chan struct{}), callable: callable}
+}
+
+// complete 记录Callable的执行结果并唤醒所有等待者。只有第一次调用生效并返回true；
+// 之后的调用返回false、丢弃结果并记录一条日志，重复完成通常意味着逻辑错误。
+func (f *Future) complete(ret interface{}, err error, exception interface{}) bool {
+	if !atomic.CompareAndSwapInt32(&f.completed, 0, 1) {
+		fmt.Println("警告：Future已经完成过，丢弃了重复的结果:", ret, err, exception)
+		return false
+	}
+	f.finish(ret, err, exception)
+	return true
+}
+
+func (f *Future) finish(ret interface{}, err error, exception interface{}) {
+	f.ret, f.err, f.exception = ret, err, exception
+	if f.guard != nil && (err != nil || exception != nil || f.timedOut) {
+		atomic.StoreInt32(&f.guard.failed, 1)
+	}
+	close(f.done)
+	if f.onDone != nil {
+		f.onDone(f)
+	}
+}
+
+// TimeoutError 是GetResult返回的超时错误，errors.Is(err, ErrTimeout)成立。
+type TimeoutError struct {
+	Timeout time.Duration // 等待的时长
+	Started bool          // 超时时Callable是否已经开始执行（false表示仍在排队或被跳过）
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Started {
+		return fmt.Sprintf("%s 等待了%v，Callable已开始执行但未按时完成", ErrTimeout, e.Timeout)
+	}
+	return fmt.Sprintf("%s 等待了%v，Callable尚未开始执行", ErrTimeout, e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error { return ErrTimeout }
+
+func (f *Future) timeoutError(timeout time.Duration) *TimeoutError {
+	return &TimeoutError{Timeout: timeout, Started: atomic.LoadInt32(&f.state) == futureRunning}
+}
+
+func (f *Future) markRead() {
+	if f.guard != nil {
+		atomic.StoreInt32(&f.guard.read, 1)
+	}
+}
+
+// completeTimedOut 以超时完成future，GetResult会通过timeoutError报告，TryGet等通过err报告。
+func (f *Future) completeTimedOut() bool {
+	if !atomic.CompareAndSwapInt32(&f.completed, 0, 1) {
+		return false
+	}
+	f.timedOut = true
+	f.finish(nil, nil, nil)
+	return true
+}
+
+// pastDeadline 判断future是否设置了deadline且已经超过。
+func (f *Future) pastDeadline() bool {
+	return !f.deadline.IsZero() && !time.Now().Before(f.deadline)
+}
+
+// GetResult 最多等待timeout；通过SubmitWithTimeout设置了deadline时，最多等到deadline。
+// 判断是否成功应看timeoutError、err和exception是否都为nil，而不是ret是否为nil。
+func (f *Future) GetResult(timeout time.Duration) (ret interface{}, timeoutError error, err error, exception interface{}) {
+	f.markRead()
+	wait, reported := timeout, timeout
+	if !f.deadline.IsZero() {
+		if d := time.Until(f.deadline); d < wait {
+			// 是deadline限制了等待，报告提交时设置的超时时长
+			wait, reported = d, f.timeout
+		}
+		if wait < 0 {
+			wait = 0 // 已经过了deadline
+		}
+	}
+	if f.IsDone() {
+		return f.result()
+	}
+	if f.waitingNum != nil {
+		atomic.AddInt32(f.waitingNum, 1)
+		defer atomic.AddInt32(f.waitingNum, -1)
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-f.done:
+		return f.result()
+	case <-timer.C:
+		return nil, f.timeoutError(reported), nil, nil
+	}
+}
+
+func (f *Future) result() (ret interface{}, timeoutError error, err error, exception interface{}) {
+	switch {
+	case f.timedOut:
+		return nil, f.timeoutError(f.timeout), nil, nil
+	case f.exception != nil:
+		fmt.Println("future 获取到了异常：", f.exception)
+		return nil, nil, nil, f.exception
+	case f.err != nil:
+		fmt.Println("future 获取到了错误：", f.err)
+		return nil, nil, f.err, nil
+	default:
+		fmt.Println("future 获取到了结果：", f.ret)
+		return f.ret, nil, nil, nil
+	}
+}
+
+// Cancel 取消一个尚未开始执行的Future，其Callable将不会再被执行，
+// GetResult会得到ErrCancelled错误。已经开始执行的Callable无法被中断，此时返回false。
+func (f *Future) Cancel() bool {
+	if !atomic.CompareAndSwapInt32(&f.state, futurePending, futureCancelled) {
+		return false
+	}
+	f.complete(nil, ErrCancelled, nil)
+	return true
+}
+
+// GetResultWithCancel 与GetResult相同，但超时后会顺带调用Cancel，
+// 避免已经无人等待的Callable继续占用goroutine。已经开始执行的Callable仍会执行完毕。
+func (f *Future) GetResultWithCancel(timeout time.Duration) (ret interface{}, timeoutError error, err error, exception interface{}) {
+	ret, timeoutError, err, exception = f.GetResult(timeout)
+	if timeoutError != nil {
+		f.Cancel()
+	}
+	return
+}
+
+// GetField 取出SubmitMulti提交的Callable返回的map中名为name的值，可以多次调用。
+// 超时、出错、异常以及字段不存在时都通过error返回。
+func (f *Future) GetField(name string, timeout time.Duration) (interface{}, error) {
+	ret, timeoutError, err, exception := f.GetResult(timeout)
+	switch {
+	case timeoutError != nil:
+		return nil, timeoutError
+	case err != nil:
+		return nil, err
+	case exception != nil:
+		return nil, fmt.Errorf("Callable发生异常: %v", exception)
+	}
+	fields, ok := ret.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("结果不是map[string]interface{}: %T", ret)
+	}
+	v, ok := fields[name]
+	if !ok {
+		return nil, fmt.Errorf("结果中没有字段 %q", name)
+	}
+	return v, nil
+}
+
+// Done 返回一个在Future完成（包括被取消）时关闭的chan，可以与其他chan一起select，
+// 之后再用GetResult取结果也不会阻塞。
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *Future) IsDone() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// TryGet 不阻塞地读取结果，Future尚未完成时done为false，其余返回值均为零值。
+// 以超时完成的Future（见SubmitWithTimeout和WithTimeout）的err是*TimeoutError。
+func (f *Future) TryGet() (ret interface{}, err error, exception interface{}, done bool) {
+	f.markRead()
+	if !f.IsDone() {
+		return nil, nil, nil, false
+	}
+	if f.timedOut {
+		return nil, f.timeoutError(f.timeout), nil, true
+	}
+	return f.ret, f.err, f.exception, true
+}
+
+// Poll 是TryGet的另一种写法，done在前，便于在轮询循环中先判断是否完成。
+func (f *Future) Poll() (done bool, ret interface{}, err error, exception interface{}) {
+	ret, err, exception, done = f.TryGet()
+	return
+}
+
+// Class 返回已完成Future的错误分类，没有错误、尚未完成或错误不是Callable返回的时为ErrorUnknown。
+func (f *Future) Class() ErrorClass {
+	if !f.IsDone() {
+		return ErrorUnknown
+	}
+	return f.class
+}
+
+// Value 返回已完成Future的结果，未完成时返回nil。
+func (f *Future) Value() interface{} {
+	ret, _, _, _ := f.TryGet()
+	return ret
+}
+
+// Error 返回已完成Future的错误，未完成时返回nil。
+func (f *Future) Error() error {
+	_, err, _, _ := f.TryGet()
+	return err
+}
+
+// Exception 返回已完成Future捕获到的panic值，未完成时返回nil。
+func (f *Future) Exception() interface{} {
+	_, _, exception, _ := f.TryGet()
+	return exception
+}
+
+// WithTimeout 返回一个派生的Future：原Future在d内完成时得到相同的结果，
+// 否则以超时完成（GetResult通过timeoutError、TryGet和Poll通过err报告*TimeoutError），并尝试取消尚未开始的原Future。
+// 原Future先完成时定时器随即停止，不会遗留goroutine。
+func (f *Future) WithTimeout(d time.Duration) *Future {
+	derived := newFuture(nil)
+	derived.timeout = d
+	timer := time.AfterFunc(d, func() {
+		// 让TimeoutError.Started反映原Future是否已开始执行
+		atomic.CompareAndSwapInt32(&derived.state, futurePending, atomic.LoadInt32(&f.state))
+		if derived.completeTimedOut() {
+			f.Cancel()
+		}
+	})
+	go func() {
+		select {
+		case <-f.done:
+			timer.Stop()
+			derived.copyFrom(f)
+		case <-derived.done:
+		}
+	}()
+	return derived
+}
+
+func (f *Future) copyFrom(src *Future) {
+	if !atomic.CompareAndSwapInt32(&f.completed, 0, 1) {
+		return
+	}
+	f.timedOut = src.timedOut
+	f.timeout = src.timeout
+	f.class = src.class
+	f.finish(src.ret, src.err, src.exception)
+}
@@ -0,0 +1,168 @@
+package executors
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetResultNilResult(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	ret, timeoutError, err, exception := es.Submit(returning(nil)).GetResult(time.Second)
+	if ret != nil || timeoutError != nil || err != nil || exception != nil {
+		t.Fatal(ret, timeoutError, err, exception)
+	}
+}
+
+func TestGetResultTimeout(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	block := make(chan struct{})
+	defer close(block)
+	f := es.Submit(func() (interface{}, error) {
+		<-block
+		return nil, nil
+	})
+	_, timeoutError, _, _ := f.GetResult(10 * time.Millisecond)
+	var te *TimeoutError
+	if !errors.As(timeoutError, &te) || !errors.Is(timeoutError, ErrTimeout) || te.Timeout != 10*time.Millisecond {
+		t.Fatal(timeoutError)
+	}
+}
+
+func TestSubmitWithTimeout(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	block := make(chan struct{})
+	defer close(block)
+	f := es.SubmitWithTimeout(func() (interface{}, error) {
+		<-block
+		return 1, nil
+	}, 10*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	_, timeoutError, _, _ := f.GetResult(time.Second)
+	var te *TimeoutError
+	if !errors.As(timeoutError, &te) || te.Timeout != 10*time.Millisecond || !te.Started {
+		t.Fatal("应报告SubmitWithTimeout设置的超时时长:", timeoutError)
+	}
+}
+
+func TestTryGetReportsTimeout(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	f := es.SubmitWithTimeout(func() (interface{}, error) {
+		time.Sleep(30 * time.Millisecond)
+		return 1, nil
+	}, 10*time.Millisecond)
+	<-f.Done()
+	ret, err, exception, done := f.TryGet()
+	if !done || ret != nil || !errors.Is(err, ErrTimeout) || exception != nil {
+		t.Fatal(ret, err, exception, done)
+	}
+	if !errors.Is(f.Error(), ErrTimeout) {
+		t.Fatal(f.Error())
+	}
+}
+
+func TestWithTimeoutPoll(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	f := es.Submit(func() (interface{}, error) {
+		time.Sleep(50 * time.Millisecond)
+		return 1, nil
+	}).WithTimeout(5 * time.Millisecond)
+	<-f.Done()
+	done, ret, err, _ := f.Poll()
+	if !done || ret != nil || !errors.Is(err, ErrTimeout) {
+		t.Fatal(done, ret, err)
+	}
+	fast := es.Submit(returning(2)).WithTimeout(time.Second)
+	if ret, _, _, _ := fast.GetResult(time.Second); ret != 2 {
+		t.Fatal(ret)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	release := occupyAll(es)
+	f := es.Submit(returning(1))
+	if !f.Cancel() {
+		t.Fatal("尚未开始的Future应能取消")
+	}
+	release()
+	if _, _, err, _ := f.GetResult(time.Second); err != ErrCancelled {
+		t.Fatal(err)
+	}
+	done := es.Submit(returning(1))
+	done.GetResult(time.Second)
+	if done.Cancel() {
+		t.Fatal("已完成的Future不能取消")
+	}
+}
+
+func TestCompleteOnlyOnce(t *testing.T) {
+	f := newFuture(nil)
+	if !f.complete(1, nil, nil) {
+		t.Fatal("第一次完成应返回true")
+	}
+	if f.complete(2, nil, nil) {
+		t.Fatal("重复完成应返回false")
+	}
+	if f.Value() != 1 {
+		t.Fatal("重复完成不应覆盖结果:", f.Value())
+	}
+}
+
+func TestSubmitGroupTimeoutReportsBudget(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	block := make(chan struct{})
+	defer close(block)
+	g := es.SubmitGroup([]Callable{func() (interface{}, error) {
+		<-block
+		return nil, nil
+	}}, 10*time.Millisecond)
+	if g.Wait() {
+		t.Fatal("应超过共享的截止时间")
+	}
+	_, timeoutError, _, _ := g.Futures[0].GetResult(time.Second)
+	var te *TimeoutError
+	if !errors.As(timeoutError, &te) || te.Timeout != 10*time.Millisecond {
+		t.Fatal("应报告组的超时时长:", timeoutError)
+	}
+}
+
+func TestSubmitGroup(t *testing.T) {
+	es := NewExecutors()
+	defer es.Stop()
+	g := es.SubmitGroup([]Callable{returning(1), returning(2), returning(3)}, time.Second)
+	if !g.Wait() {
+		t.Fatal("按时完成的组Wait应返回true")
+	}
+	for i, f := range g.Futures {
+		if f.Value() != i+1 {
+			t.Fatal(i, f.Value())
+		}
+	}
+	queued := newExecutorsWithoutGo(2)
+	defer queued.Stop()
+	var ran int32
+	count := func() (interface{}, error) {
+		atomic.AddInt32(&ran, 1)
+		return nil, nil
+	}
+	late := queued.SubmitGroup([]Callable{count, count}, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	queued.executeBatch(2)
+	if late.Wait() || atomic.LoadInt32(&ran) != 0 {
+		t.Fatal("截止时间之后才轮到的Callable不应执行:", ran)
+	}
+	for _, f := range late.Futures {
+		if !errors.Is(f.Error(), ErrTimeout) {
+			t.Fatal(f.Error())
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package executors
+
+import (
+	"context"
+)
+
+// Semaphore 是基于带缓冲chan的计数信号量，可单独用来限制一组goroutine的并发数。
+type Semaphore struct {
+	slots chan struct{}
+}
+
+func NewSemaphore(n int) *Semaphore {
+	if n < 1 {
+		n = 1
+	}
+	return &Semaphore{slots: make(chan struct{}, n)}
+}
+
+// Acquire 获取一个许可，ctx被取消时放弃等待并返回ctx.Err()。
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TryAcquire 不等待地尝试获取一个许可。
+func (s *Semaphore) TryAcquire() bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release 归还一个许可，归还次数多于获取次数时panic。
+func (s *Semaphore) Release() {
+	select {
+	case <-s.slots:
+	default:
+		panic("Semaphore.Release的次数多于Acquire！")
+	}
+}
@@ -0,0 +1,38 @@
+package executors
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	s := NewSemaphore(2)
+	if err := s.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !s.TryAcquire() {
+		t.Fatal("仍有许可时TryAcquire应成功")
+	}
+	if s.TryAcquire() {
+		t.Fatal("许可用完后TryAcquire应失败")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatal("许可用完后Acquire应等到ctx结束:", err)
+	}
+	s.Release()
+	if !s.TryAcquire() {
+		t.Fatal("Release后应能再次获取")
+	}
+}
+
+func TestSemaphoreReleaseWithoutAcquire(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("多余的Release应panic")
+		}
+	}()
+	NewSemaphore(1).Release()
+}